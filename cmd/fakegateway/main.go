@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func main() {
+	if err := run(os.Args, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	var (
+		fs       = flag.NewFlagSet("fakegateway", flag.ExitOnError)
+		addr     = fs.String("addr", "127.0.0.1:5684", "UDP listen address")
+		code     = fs.String("code", "0123456789abcdef", "security code")
+		username = fs.String("username", "", "pre-provisioned username (optional)")
+		psk      = fs.String("psk", "", "pre-provisioned PSK (optional)")
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	g := fakegateway.New(*code)
+	if *username != "" {
+		g.AddIdentity(*username, *psk)
+	}
+	seed(g)
+
+	if err := g.Listen(*addr); err != nil {
+		return err
+	}
+	defer g.Close()
+
+	fmt.Fprintf(stderr, "serving fake gateway on %s (security code %s)\n", g.URL(), *code)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+	return nil
+}
+
+func seed(g *fakegateway.Gateway) {
+	var (
+		kitchen = light(g, "Kitchen ceiling", "TRADFRI bulb E27 WS opal 980lm")
+		counter = light(g, "Kitchen counter", "TRADFRI bulb GU10 WS 400lm")
+		sofa    = light(g, "Living room sofa", "TRADFRI bulb E27 CWS opal 600lm")
+		floor   = light(g, "Living room floor", "TRADFRI bulb E27 WS opal 980lm")
+	)
+
+	var remote coap.Device
+	remote.Name = "Living room remote"
+	remote.Reachable = 1
+	remote.DeviceInfo.Manufacturer = "IKEA of Sweden"
+	remote.DeviceInfo.Model = "TRADFRI remote control"
+	remote.DeviceInfo.Firmware = "2.3.014"
+	remote.DeviceInfo.PowerSource = 3
+	remote.DeviceInfo.BatteryLevel = 87
+	g.AddDevice(remote)
+
+	for _, gr := range []struct {
+		name    string
+		members []int
+	}{
+		{"Kitchen", []int{kitchen, counter}},
+		{"Living room", []int{sofa, floor}},
+	} {
+		id := group(g, gr.name, gr.members...)
+		mood(g, id, "EVERYDAY", 1, 254, 370, gr.members...)
+		mood(g, id, "RELAX", 2, 100, 454, gr.members...)
+	}
+}
+
+func light(g *fakegateway.Gateway, name, model string) int {
+	var d coap.Device
+	d.Name = name
+	d.Reachable = 1
+	d.DeviceInfo.Manufacturer = "IKEA of Sweden"
+	d.DeviceInfo.Model = model
+	d.DeviceInfo.Firmware = "1.3.009"
+	d.DeviceInfo.PowerSource = 6
+	d.LightControl = []coap.LightControl{{
+		State:         1,
+		Dimmer:        254,
+		LightColorHex: "f1e0b5",
+		LightMireds:   370,
+	}}
+	return g.AddDevice(d)
+}
+
+func group(g *fakegateway.Gateway, name string, members ...int) int {
+	var gr coap.Group
+	gr.Name = name
+	gr.State = 1
+	gr.Dimmer = 254
+	gr.GroupMembers.HSLink.IDs = members
+	return g.AddGroup(gr)
+}
+
+func mood(g *fakegateway.Gateway, groupID int, name string, index int, dimmer coap.Percent255, mireds int, members ...int) {
+	var m coap.Mood
+	m.Name = name
+	m.Predefined = 1
+	m.Index = index
+	for _, id := range members {
+		m.Lights = append(m.Lights, coap.MoodLight{ID: id, State: 1, Dimmer: dimmer, LightMireds: mireds})
+	}
+	g.AddMood(groupID, m)
+}
@@ -6,8 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
+	"os/signal"
 
 	"github.com/peterbourgon/ff/v2"
 	"github.com/peterbourgon/ff/v2/ffcli"
@@ -28,22 +28,36 @@ func main() {
 
 func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	var (
-		options    = []ff.Option{ff.WithEnvVarNoPrefix()}
 		rootfs     = flag.NewFlagSet("lightctl", flag.ExitOnError)
-		gateway    = rootfs.String("gateway", "udp://10.0.1.11:5684", "TRÅDFRI gateway address")
-		gatewayURL url.URL
+		gateway    = rootfs.String("gateway", "", "TRÅDFRI gateway address, overrides the profile")
+		profile    = rootfs.String("profile", "", "gateway profile, default from config")
+		output     = rootfs.String("output", "text", "output format: text, json, yaml, ndjson, csv (csv is not supported for moods, tasks, or gateway info)")
+		debug      = rootfs.Bool("debug", false, "trace requests to the gateway to stderr, including payloads")
+		rootConfig command.RootConfig
 	)
+	rootfs.BoolVar(debug, "v", false, "alias for -debug")
 
 	root := &ffcli.Command{
 		ShortUsage: "lightctl <subcommand> ...",
+		LongHelp:   "Each of the flags may also be given as an environment variable with the LIGHTCTL_ prefix, e.g. LIGHTCTL_GATEWAY.",
 		Subcommands: []*ffcli.Command{
-			command.Sun(stdout, stderr),
-			command.Auth(&gatewayURL, stdout, stderr),
-			command.Device(&gatewayURL, stdout, stderr),
-			command.Group(&gatewayURL, stdout, stderr),
+			command.Sun(&rootConfig, stdout, stderr),
+			command.Discover(&rootConfig, stdout, stderr),
+			command.Auth(&rootConfig, stdout, stderr),
+			command.Profile(&rootConfig, stdout, stderr),
+			command.Gateway(&rootConfig, stdout, stderr),
+			command.Device(&rootConfig, stdout, stderr),
+			command.Group(&rootConfig, stdout, stderr),
+			command.Mood(&rootConfig, stdout, stderr),
+			command.Task(&rootConfig, stdout, stderr),
+			command.Watch(&rootConfig, stdout, stderr),
+			command.Circadian(&rootConfig, stdout, stderr),
+			command.Schedule(&rootConfig, stdout, stderr),
+			command.Serve(&rootConfig, stdout, stderr),
+			command.Exporter(&rootConfig, stdout, stderr),
 		},
 		FlagSet: rootfs,
-		Options: options,
+		Options: []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
 		Exec:    func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 
@@ -51,13 +65,32 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 		return fmt.Errorf("error during Parse: %w", err)
 	}
 
-	u, err := url.Parse(*gateway)
-	if err != nil {
-		return fmt.Errorf("error parsing gateway: %w", err)
+	if err := command.ValidOutputFormat(*output); err != nil {
+		return err
+	}
+
+	rootConfig.Gateway = *gateway
+	rootConfig.Profile = *profile
+	rootConfig.Output = *output
+	if *debug {
+		rootConfig.Trace = stderr
 	}
-	gatewayURL = *u
 
-	err = root.Run(context.Background())
+	// The first interrupt cancels the command, the second kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		defer signal.Stop(c)
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := root.Run(ctx)
 	switch {
 	case err == nil:
 		return nil
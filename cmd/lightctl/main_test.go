@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/config"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { config.FilePath = path }(config.FilePath)
+	config.FilePath = filepath.Join(dir, "lightctl.conf")
+
+	const code = "0123456789abcdef"
+	g := fakegateway.New(code)
+	var d coap.Device
+	d.Name = "Kitchen ceiling"
+	d.Reachable = 1
+	d.LightControl = []coap.LightControl{{State: 1, Dimmer: 254, LightMireds: 370}}
+	id := g.AddDevice(d)
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	lightctl := func(args ...string) string {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		if err := run(append([]string{"lightctl"}, args...), strings.NewReader(""), &stdout, &stderr); err != nil {
+			t.Fatalf("lightctl %s: %v (%s)", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String()
+	}
+
+	lightctl("-gateway", g.URL(), "auth", "-username", "test", "-code", code)
+
+	if want, have := "Kitchen ceiling", lightctl("device", "list"); !strings.Contains(have, want) {
+		t.Errorf("device list: want %q in output, have %q", want, have)
+	}
+
+	csv := strings.Split(strings.TrimSpace(lightctl("-output", "csv", "device", "list")), "\n")
+	if want, have := 2, len(csv); want != have {
+		t.Fatalf("device list as csv: want %d lines, have %d: %q", want, have, csv)
+	}
+	if want, have := "id,name,", csv[0]; !strings.HasPrefix(have, want) {
+		t.Errorf("device list as csv: want header starting with %q, have %q", want, have)
+	}
+	if want, have := "Kitchen ceiling", csv[1]; !strings.Contains(have, want) {
+		t.Errorf("device list as csv: want %q in record, have %q", want, have)
+	}
+
+	// Root flags may be given as prefixed environment variables only.
+	defer os.Unsetenv("OUTPUT")
+	defer os.Unsetenv("LIGHTCTL_OUTPUT")
+	os.Setenv("OUTPUT", "csv")
+	if want, have := fmt.Sprintf("%d: Kitchen ceiling", id), lightctl("device", "list"); !strings.HasPrefix(have, want) {
+		t.Errorf("device list with OUTPUT=csv: want text output, have %q", have)
+	}
+	os.Setenv("LIGHTCTL_OUTPUT", "csv")
+	if want, have := "id,name,", lightctl("device", "list"); !strings.HasPrefix(have, want) {
+		t.Errorf("device list with LIGHTCTL_OUTPUT=csv: want csv output, have %q", have)
+	}
+	os.Unsetenv("LIGHTCTL_OUTPUT")
+
+	lightctl("device", "set", "light", "level", "-name", "kitchen ceiling", "-level", "50")
+	d, _ = g.Device(id)
+	if want, have := coap.Percent255(127), d.LightControl[0].Dimmer; want != have {
+		t.Errorf("device set light level: want dimmer %d, have %d", want, have)
+	}
+
+	// Authenticating with another gateway mustn't clobber the default profile.
+	other := fakegateway.New(code)
+	if err := other.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	lightctl("-gateway", other.URL(), "auth", "-username", "test", "-code", code)
+
+	c, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"127.0.0.1", "default"}, c.Names(); strings.Join(want, " ") != strings.Join(have, " ") {
+		t.Errorf("profiles: want %v, have %v", want, have)
+	}
+	if want, have := g.URL(), c.Profiles["default"].Gateway; want != have {
+		t.Errorf("default profile: want gateway %s, have %s", want, have)
+	}
+	if want, have := other.URL(), c.Profiles["127.0.0.1"].Gateway; want != have {
+		t.Errorf("new profile: want gateway %s, have %s", want, have)
+	}
+}
@@ -8,4 +8,6 @@ require (
 	github.com/peterbourgon/ff/v2 v2.0.0
 	github.com/pion/dtls/v2 v2.0.0-rc.5
 	github.com/sixdouglas/suncalc v0.0.0-20190521105718-f30ff7e4f358
+	golang.org/x/net v0.0.0-20191014212845-da9a3fd4c582
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,228 @@
+// Package circadian computes light settings that follow the course of the sun.
+package circadian
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sixdouglas/suncalc"
+	"gopkg.in/yaml.v2"
+)
+
+// SunHeight returns the height of the sun at the given time and place, from 0
+// at sunrise, to 1 at solar noon, back to 0 at sunset. It's linear in time in
+// between, and 0 during the night. Where the sun doesn't rise or set on that
+// day, it's 1 if the sun is up, and 0 otherwise.
+func SunHeight(t time.Time, latitude, longitude float64) float64 {
+	var (
+		times   = suncalc.GetTimes(t, latitude, longitude)
+		sunrise = times[suncalc.Sunrise].Time
+		noon    = times[suncalc.SolarNoon].Time
+		sunset  = times[suncalc.Sunset].Time
+	)
+	if !sunrise.Before(noon) || !noon.Before(sunset) {
+		if suncalc.GetPosition(t, latitude, longitude).Altitude > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	switch {
+	case t.Before(sunrise):
+		return 0
+	case t.Before(noon):
+		return float64(t.Sub(sunrise)) / float64(noon.Sub(sunrise))
+	case t.Before(sunset):
+		return 1 - (float64(t.Sub(noon)) / float64(sunset.Sub(noon)))
+	default:
+		return 0
+	}
+}
+
+// Event is a solar event, e.g. sunrise.
+type Event struct {
+	Name string // e.g. sunrise, golden_hour
+	Time time.Time
+}
+
+// EventNames are the names of the solar events, in their order during a day.
+// Nadir is the darkest moment of the preceding night.
+var EventNames = []string{
+	"nadir",
+	"night_end",
+	"nautical_dawn",
+	"dawn",
+	"sunrise",
+	"sunrise_end",
+	"golden_hour_end",
+	"solar_noon",
+	"golden_hour",
+	"sunset_start",
+	"sunset",
+	"dusk",
+	"nautical_dusk",
+	"night",
+}
+
+var suncalcNames = map[string]suncalc.DayTimeName{
+	"nadir":           suncalc.Nadir,
+	"night_end":       suncalc.NightEnd,
+	"nautical_dawn":   suncalc.NauticalDawn,
+	"dawn":            suncalc.Dawn,
+	"sunrise":         suncalc.Sunrise,
+	"sunrise_end":     suncalc.SunriseEnd,
+	"golden_hour_end": suncalc.GoldenHourEnd,
+	"solar_noon":      suncalc.SolarNoon,
+	"golden_hour":     suncalc.GoldenHour,
+	"sunset_start":    suncalc.SunsetStart,
+	"sunset":          suncalc.Sunset,
+	"dusk":            suncalc.Dusk,
+	"nautical_dusk":   suncalc.NauticalDusk,
+	"night":           suncalc.Night,
+}
+
+// Events returns the solar events of the day of the given date, in the
+// location of the date, ordered by time. Events that don't occur on that day,
+// e.g. night during the summer in the far north, are omitted.
+func Events(date time.Time, latitude, longitude float64) []Event {
+	var (
+		year, month, day = date.Date()
+		noon             = time.Date(year, month, day, 12, 0, 0, 0, date.Location())
+		times            = suncalc.GetTimes(noon, latitude, longitude)
+		solarNoon        = times[suncalc.SolarNoon].Time
+		events           = make([]Event, 0, len(EventNames))
+	)
+	for _, name := range EventNames {
+		t := times[suncalcNames[name]].Time
+		if d := t.Sub(solarNoon); d < -24*time.Hour || d > 24*time.Hour {
+			continue // suncalc's representation of NaN
+		}
+		events = append(events, Event{Name: name, Time: t.In(date.Location())})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events
+}
+
+// Range is an inclusive range of a light property, 0..100. The minimum is
+// used at night, and the maximum when the sun is at its height.
+type Range struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// At returns the value of the range for the given sun height.
+func (r Range) At(height float64) int {
+	return r.Min + int(math.Round(height*float64(r.Max-r.Min)))
+}
+
+func (r Range) validate() error {
+	if r.Min < 0 || r.Max > 100 || r.Min > r.Max {
+		return fmt.Errorf("invalid range %d..%d", r.Min, r.Max)
+	}
+	return nil
+}
+
+// Default ranges, for groups that don't specify their own.
+var (
+	DefaultLevel = Range{Min: 10, Max: 100}
+	DefaultWhite = Range{Min: 0, Max: 100}
+)
+
+// Group is the circadian configuration of a single group.
+type Group struct {
+	Name  string `yaml:"name"`  // group name or glob pattern
+	Level *Range `yaml:"level"` // 0..100
+	White *Range `yaml:"white"` // 0..100 (0=red, 100=white)
+}
+
+// Setting is the light setting of a group at a specific sun height.
+type Setting struct {
+	Level int // 0..100
+	White int // 0..100 (0=red, 100=white)
+}
+
+// At returns the setting of the group for the given sun height.
+func (g Group) At(height float64) Setting {
+	level, white := DefaultLevel, DefaultWhite
+	if g.Level != nil {
+		level = *g.Level
+	}
+	if g.White != nil {
+		white = *g.White
+	}
+	return Setting{Level: level.At(height), White: white.At(height)}
+}
+
+// Config is the configuration of the circadian daemon, usually read from a
+// YAML file, e.g.
+//
+//	latitude: 52.520008
+//	longitude: 13.404954
+//	groups:
+//	- name: Kitchen
+//	  level: {min: 30, max: 100}
+//	- name: Bedroom*
+//	  level: {min: 5, max: 80}
+//	  white: {min: 0, max: 60}
+type Config struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	Groups    []Group `yaml:"groups"`
+}
+
+// ReadConfig reads and validates a config in YAML. The latitude and longitude
+// are required.
+func ReadConfig(r io.Reader) (Config, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var c Config
+	if err := yaml.UnmarshalStrict(buf, &c); err != nil {
+		return Config{}, err
+	}
+
+	// A missing latitude or longitude would decode as 0, which is a valid,
+	// but surely unintended, location.
+	var location struct {
+		Latitude  *float64 `yaml:"latitude"`
+		Longitude *float64 `yaml:"longitude"`
+	}
+	if err := yaml.Unmarshal(buf, &location); err != nil {
+		return Config{}, err
+	}
+	if location.Latitude == nil || location.Longitude == nil {
+		return Config{}, fmt.Errorf("latitude and longitude are required")
+	}
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return Config{}, fmt.Errorf("invalid latitude %v", c.Latitude)
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return Config{}, fmt.Errorf("invalid longitude %v", c.Longitude)
+	}
+	if len(c.Groups) == 0 {
+		return Config{}, fmt.Errorf("no groups")
+	}
+	for i, g := range c.Groups {
+		if g.Name == "" {
+			return Config{}, fmt.Errorf("group %d: name is required", i+1)
+		}
+		if g.Level != nil {
+			if err := g.Level.validate(); err != nil {
+				return Config{}, fmt.Errorf("group %s: level: %w", g.Name, err)
+			}
+		}
+		if g.White != nil {
+			if err := g.White.validate(); err != nil {
+				return Config{}, fmt.Errorf("group %s: white: %w", g.Name, err)
+			}
+		}
+	}
+
+	return c, nil
+}
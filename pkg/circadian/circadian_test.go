@@ -0,0 +1,127 @@
+package circadian
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	berlinLatitude, berlinLongitude = 52.520008, 13.404954
+	tromsoLatitude, tromsoLongitude = 69.649208, 18.955324
+)
+
+func TestSunHeight(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name                string
+		time                time.Time
+		latitude, longitude float64
+		min, max            float64
+	}{
+		{"berlin midnight", time.Date(2026, 6, 21, 0, 0, 0, 0, berlin), berlinLatitude, berlinLongitude, 0, 0},
+		{"berlin morning", time.Date(2026, 6, 21, 8, 0, 0, 0, berlin), berlinLatitude, berlinLongitude, 0.2, 0.6},
+		{"berlin solar noon", time.Date(2026, 6, 21, 13, 8, 0, 0, berlin), berlinLatitude, berlinLongitude, 0.99, 1},
+		{"berlin evening", time.Date(2026, 6, 21, 18, 0, 0, 0, berlin), berlinLatitude, berlinLongitude, 0.2, 0.6},
+		{"berlin night", time.Date(2026, 6, 21, 22, 30, 0, 0, berlin), berlinLatitude, berlinLongitude, 0, 0},
+		{"berlin winter noon", time.Date(2026, 12, 21, 12, 15, 0, 0, berlin), berlinLatitude, berlinLongitude, 0.95, 1},
+		{"tromsø midnight sun", time.Date(2026, 6, 21, 23, 0, 0, 0, time.UTC), tromsoLatitude, tromsoLongitude, 1, 1},
+		{"tromsø polar night", time.Date(2026, 12, 21, 11, 0, 0, 0, time.UTC), tromsoLatitude, tromsoLongitude, 0, 0},
+	} {
+		if have := SunHeight(tc.time, tc.latitude, tc.longitude); have < tc.min || have > tc.max {
+			t.Errorf("%s: want %v..%v, have %v", tc.name, tc.min, tc.max, have)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name                string
+		date                time.Time
+		latitude, longitude float64
+		missing             []string
+	}{
+		{"berlin summer", time.Date(2026, 6, 21, 0, 0, 0, 0, berlin), berlinLatitude, berlinLongitude, []string{"night_end", "night"}},
+		{"berlin winter", time.Date(2026, 12, 21, 0, 0, 0, 0, berlin), berlinLatitude, berlinLongitude, nil},
+		{"tromsø summer", time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC), tromsoLatitude, tromsoLongitude, []string{"night_end", "nautical_dawn", "dawn", "sunrise", "sunrise_end", "sunset_start", "sunset", "dusk", "nautical_dusk", "night"}},
+	} {
+		events := Events(tc.date, tc.latitude, tc.longitude)
+
+		have := map[string]bool{}
+		for i, e := range events {
+			have[e.Name] = true
+			if i > 0 && e.Time.Before(events[i-1].Time) {
+				t.Errorf("%s: %s before %s", tc.name, e.Name, events[i-1].Name)
+			}
+			if e.Time.Location() != tc.date.Location() {
+				t.Errorf("%s: %s in %s, want %s", tc.name, e.Name, e.Time.Location(), tc.date.Location())
+			}
+		}
+
+		var missing []string
+		for _, name := range EventNames {
+			if !have[name] {
+				missing = append(missing, name)
+			}
+		}
+		if want, have := strings.Join(tc.missing, " "), strings.Join(missing, " "); want != have {
+			t.Errorf("%s: want missing [%s], have [%s]", tc.name, want, have)
+		}
+	}
+}
+
+func TestGroupAt(t *testing.T) {
+	for _, tc := range []struct {
+		group  Group
+		height float64
+		want   Setting
+	}{
+		{Group{}, 0, Setting{Level: 10, White: 0}},
+		{Group{}, 0.5, Setting{Level: 55, White: 50}},
+		{Group{}, 1, Setting{Level: 100, White: 100}},
+		{Group{Level: &Range{Min: 5, Max: 80}, White: &Range{Min: 0, Max: 60}}, 0, Setting{Level: 5, White: 0}},
+		{Group{Level: &Range{Min: 5, Max: 80}, White: &Range{Min: 0, Max: 60}}, 0.25, Setting{Level: 24, White: 15}},
+		{Group{Level: &Range{Min: 5, Max: 80}, White: &Range{Min: 0, Max: 60}}, 1, Setting{Level: 80, White: 60}},
+		{Group{Level: &Range{Min: 40, Max: 40}}, 0.7, Setting{Level: 40, White: 70}},
+	} {
+		if have := tc.group.At(tc.height); tc.want != have {
+			t.Errorf("%+v at %v: want %+v, have %+v", tc.group, tc.height, tc.want, have)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"valid", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- name: Kitchen\n  level: {min: 30, max: 100}\n", false},
+		{"default ranges", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- name: Kitchen\n", false},
+		{"zero location", "latitude: 0\nlongitude: 0\ngroups:\n- name: Kitchen\n", false},
+		{"no location", "groups:\n- name: Kitchen\n", true},
+		{"no latitude", "longitude: 13.4\ngroups:\n- name: Kitchen\n", true},
+		{"no longitude", "latitude: 52.5\ngroups:\n- name: Kitchen\n", true},
+		{"no groups", "latitude: 52.5\nlongitude: 13.4\n", true},
+		{"no name", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- level: {min: 30, max: 100}\n", true},
+		{"unknown field", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- name: Kitchen\n  colour: red\n", true},
+		{"invalid latitude", "latitude: 91\nlongitude: 13.4\ngroups:\n- name: Kitchen\n", true},
+		{"invalid longitude", "latitude: 52.5\nlongitude: -181\ngroups:\n- name: Kitchen\n", true},
+		{"inverted range", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- name: Kitchen\n  level: {min: 80, max: 20}\n", true},
+		{"range out of bounds", "latitude: 52.5\nlongitude: 13.4\ngroups:\n- name: Kitchen\n  white: {min: 0, max: 101}\n", true},
+	} {
+		_, err := ReadConfig(strings.NewReader(tc.input))
+		if tc.wantErr != (err != nil) {
+			t.Errorf("%s: want error %v, have %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
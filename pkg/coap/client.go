@@ -1,46 +1,71 @@
 package coap
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"os"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
 	"github.com/pion/dtls/v2"
 )
 
 const (
-	RootDevices = 15001
-	RootGroups  = 15004
+	RootDevices    = 15001
+	RootGroups     = 15004
+	RootMoods      = 15005
+	RootSmartTasks = 15010
+	RootGateway    = 15011
 )
 
+// Client is a connection to a TRÅDFRI gateway. It keeps a single DTLS session
+// alive, and transparently establishes a new one when it's lost. It's safe for
+// concurrent use.
 type Client struct {
-	conn *coap.ClientConn
-}
-
-func NewClient(network, address, username, psk string) (*Client, error) {
-	conn, err := coap.DialDTLSWithTimeout(network, address, &dtls.Config{
-		PSK:             func(hint []byte) ([]byte, error) { return []byte(psk), nil },
-		PSKIdentityHint: []byte(username),
-		CipherSuites: []dtls.CipherSuiteID{
-			dtls.TLS_PSK_WITH_AES_128_CCM,
-			dtls.TLS_PSK_WITH_AES_128_CCM_8,
-			dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
-		},
-	}, 3*time.Second)
-	if err != nil {
-		return nil, err
+	network        string
+	address        string
+	dtlsConfig     *dtls.Config
+	dialTimeout    time.Duration
+	requestTimeout time.Duration
+	keepAlive      time.Duration
+	concurrency    int
+	retryPolicy    RetryPolicy
+	tracer         Tracer
+
+	mtx     sync.Mutex
+	session *session
+	closed  bool
+}
+
+// NewClient establishes a session with the gateway at the address. The network
+// is typically "udp". The username and PSK are from a successful Auth request,
+// or the "Client_identity" username and the security code of the gateway.
+func NewClient(ctx context.Context, network, address, username, psk string, options ...Option) (*Client, error) {
+	c := &Client{
+		network:        dtlsNetwork(network),
+		address:        address,
+		dtlsConfig:     dtlsConfig(username, psk),
+		dialTimeout:    defaultDialTimeout,
+		requestTimeout: defaultRequestTimeout,
+		keepAlive:      defaultKeepAlive,
+		concurrency:    defaultConcurrency,
+		retryPolicy:    DefaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if _, err := c.connect(ctx); err != nil {
+		return nil, timeout("", err)
 	}
 
-	return &Client{
-		conn: conn,
-	}, nil
+	return c, nil
 }
 
-func (c *Client) Auth(username string) (psk string, err error) {
+func (c *Client) Auth(ctx context.Context, username string) (psk string, err error) {
 	buf, err := json.Marshal(struct {
 		Username string `json:"9090"`
 	}{Username: username})
@@ -48,13 +73,14 @@ func (c *Client) Auth(username string) (psk string, err error) {
 		return "", fmt.Errorf("error marshaling request payload: %w", err)
 	}
 
-	msg, err := c.conn.Post("/15011/9063", coap.AppJSON, bytes.NewReader(buf))
+	path := fmt.Sprintf("/%d/%d", RootGateway, gatewayAuth)
+	msg, err := c.exchange(ctx, codes.POST, path, buf)
 	if err != nil {
 		return "", fmt.Errorf("error making request: %w", err)
 	}
 
-	if msg.Code() > 100 {
-		return "", fmt.Errorf("response code %d (%s)", msg.Code(), msg.Code().String())
+	if err := checkResponse(path, msg.Code()); err != nil {
+		return "", err
 	}
 
 	var response struct {
@@ -68,66 +94,186 @@ func (c *Client) Auth(username string) (psk string, err error) {
 	return response.PreSharedKey, nil
 }
 
-func (c *Client) GetDevice(id int) (d Device, err error) {
-	err = c.get(fmt.Sprintf("/15001/%d", id), &d)
+func (c *Client) GetDevice(ctx context.Context, id int) (d Device, err error) {
+	err = c.get(ctx, fmt.Sprintf("/15001/%d", id), &d)
 	return d, err
 }
 
-func (c *Client) ListDevices() ([]Device, error) {
+// ListDevices returns all devices, ordered as listed by the gateway. The
+// devices are fetched concurrently. If some of them can't be fetched, the rest
+// are returned with a *PartialError.
+func (c *Client) ListDevices(ctx context.Context) ([]Device, error) {
 	var ids []int
-	if err := c.get("/15001", &ids); err != nil {
+	if err := c.get(ctx, "/15001", &ids); err != nil {
 		return nil, fmt.Errorf("error listing device IDs: %w", err)
 	}
 
+	fetched := make([]Device, len(ids))
+	errs := c.fetchEach(ctx, len(ids), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = c.GetDevice(ctx, ids[i])
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var devices []Device
-	for _, id := range ids {
-		d, err := c.GetDevice(id)
-		if err != nil {
-			return nil, fmt.Errorf("error getting device %d: %w", id, err)
+	for i, d := range fetched {
+		if errs[i] == nil {
+			devices = append(devices, d)
+		}
+	}
+
+	return devices, partialError("device", ids, errs)
+}
+
+func (c *Client) RenameDevice(ctx context.Context, id int, name string) error {
+	return c.put(ctx, fmt.Sprintf("/15001/%d", id), struct {
+		Name string `json:"9001"`
+	}{
+		Name: name,
+	})
+}
+
+// RemoveDevice unpairs a device from the gateway. It must be paired again,
+// e.g. with the app, before it can be used.
+func (c *Client) RemoveDevice(ctx context.Context, id int) error {
+	return c.delete(ctx, fmt.Sprintf("/15001/%d", id))
+}
+
+// IdentifyDevice blinks a light, so it can be found physically. The gateway
+// has no identify operation for lights, so this toggles the light and back
+// count times, waiting interval after each toggle.
+func (c *Client) IdentifyDevice(ctx context.Context, id int, count int, interval time.Duration) error {
+	d, err := c.GetDevice(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error getting device %d: %w", id, err)
+	}
+	if len(d.LightControl) == 0 {
+		return fmt.Errorf("device %d isn't a light", id)
+	}
+
+	on := d.LightControl[0].State != 0
+	for i := 0; i < count; i++ {
+		for _, state := range []bool{!on, on} {
+			if err := c.SetLightControlState(ctx, RootDevices, id, state); err != nil {
+				return err
+			}
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
-		devices = append(devices, d)
 	}
 
-	return devices, nil
+	return nil
 }
 
-func (c *Client) GetGroup(id int) (g Group, err error) {
-	err = c.get(fmt.Sprintf("/15004/%d", id), &g)
+func (c *Client) GetGroup(ctx context.Context, id int) (g Group, err error) {
+	err = c.get(ctx, fmt.Sprintf("/15004/%d", id), &g)
 	return g, err
 }
 
-func (c *Client) ListGroups() ([]Group, error) {
+// ListGroups returns all groups, with the same semantics as ListDevices.
+func (c *Client) ListGroups(ctx context.Context) ([]Group, error) {
 	var ids []int
-	if err := c.get("/15004", &ids); err != nil {
+	if err := c.get(ctx, "/15004", &ids); err != nil {
 		return nil, fmt.Errorf("error listing group IDs: %w", err)
 	}
 
+	fetched := make([]Group, len(ids))
+	errs := c.fetchEach(ctx, len(ids), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = c.GetGroup(ctx, ids[i])
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	var groups []Group
-	for _, id := range ids {
-		g, err := c.GetGroup(id)
-		if err != nil {
-			return nil, fmt.Errorf("error getting group %d: %w", id, err)
+	for i, g := range fetched {
+		if errs[i] == nil {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups, partialError("group", ids, errs)
+}
+
+// CreateGroup creates a new group, and returns its ID.
+func (c *Client) CreateGroup(ctx context.Context, input GroupInput) (int, error) {
+	var response Resource
+	if err := c.post(ctx, "/15004", input, &response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+func (c *Client) UpdateGroup(ctx context.Context, id int, input GroupInput) error {
+	return c.put(ctx, fmt.Sprintf("/15004/%d", id), input)
+}
+
+func (c *Client) DeleteGroup(ctx context.Context, id int) error {
+	return c.delete(ctx, fmt.Sprintf("/15004/%d", id))
+}
+
+// AddGroupMembers adds devices to a group, keeping its existing members.
+func (c *Client) AddGroupMembers(ctx context.Context, id int, deviceIDs ...int) error {
+	g, err := c.GetGroup(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error getting group %d: %w", id, err)
+	}
+
+	members := g.GroupMembers
+	for _, deviceID := range deviceIDs {
+		if !members.Contains(deviceID) {
+			members.HSLink.IDs = append(members.HSLink.IDs, deviceID)
 		}
-		groups = append(groups, g)
 	}
 
-	return groups, nil
+	return c.UpdateGroup(ctx, id, GroupInput{Members: &members})
 }
 
-func (c *Client) SetLightControlState(root, id int, on bool) error {
+// RemoveGroupMembers removes devices from a group, keeping its other members.
+func (c *Client) RemoveGroupMembers(ctx context.Context, id int, deviceIDs ...int) error {
+	g, err := c.GetGroup(ctx, id)
+	if err != nil {
+		return fmt.Errorf("error getting group %d: %w", id, err)
+	}
+
+	var (
+		remove = NewGroupMembers(deviceIDs...)
+		keep   []int
+	)
+	for _, member := range g.GroupMembers.HSLink.IDs {
+		if !remove.Contains(member) {
+			keep = append(keep, member)
+		}
+	}
+	members := NewGroupMembers(keep...)
+
+	return c.UpdateGroup(ctx, id, GroupInput{Members: &members})
+}
+
+func (c *Client) SetLightControlState(ctx context.Context, root, id int, on bool) error {
 	var st int
 	if on {
 		st = 1
 	}
-	return c.put(fmt.Sprintf("/%d/%d", root, id), struct {
+	return c.putLight(ctx, root, id, struct {
 		State int `json:"5850"`
 	}{
 		State: st,
 	})
 }
 
-func (c *Client) SetLightControlDimmer(root, id int, dimmer int, transition time.Duration) error {
-	return c.put(fmt.Sprintf("/%d/%d", root, id), struct {
+func (c *Client) SetLightControl(ctx context.Context, root, id int, input LightControlInput) error {
+	return c.putLight(ctx, root, id, input)
+}
+
+func (c *Client) SetLightControlDimmer(ctx context.Context, root, id int, dimmer int, transition time.Duration) error {
+	return c.putLight(ctx, root, id, struct {
 		Dimmer     int `json:"5851"` // 0..255
 		Transition int `json:"5712"` // tenths of a second
 	}{
@@ -136,8 +282,8 @@ func (c *Client) SetLightControlDimmer(root, id int, dimmer int, transition time
 	})
 }
 
-func (c *Client) SetLightControlMireds(root, id int, mireds int, transition time.Duration) error {
-	return c.put(fmt.Sprintf("/%d/%d", root, id), struct {
+func (c *Client) SetLightControlMireds(ctx context.Context, root, id int, mireds int, transition time.Duration) error {
+	return c.putLight(ctx, root, id, struct {
 		Mireds     int `json:"5711"` // 250..454
 		Transition int `json:"5712"` // tenths of a second
 	}{
@@ -146,18 +292,64 @@ func (c *Client) SetLightControlMireds(root, id int, mireds int, transition time
 	})
 }
 
-func (c *Client) get(path string, response interface{}) error {
-	msg, err := c.conn.Get(path)
+func (c *Client) SetLightControlColorXY(ctx context.Context, root, id int, x, y int, transition time.Duration) error {
+	return c.putLight(ctx, root, id, struct {
+		X          int `json:"5709"` // 0..65535
+		Y          int `json:"5710"` // 0..65535
+		Transition int `json:"5712"` // tenths of a second
+	}{
+		X:          x,
+		Y:          y,
+		Transition: int(transition.Seconds() * 10),
+	})
+}
+
+func (c *Client) SetLightControlColorHueSaturation(ctx context.Context, root, id int, hue, saturation int, transition time.Duration) error {
+	return c.putLight(ctx, root, id, struct {
+		Hue        int `json:"5707"` // 0..65279
+		Saturation int `json:"5708"` // 0..65279
+		Transition int `json:"5712"` // tenths of a second
+	}{
+		Hue:        hue,
+		Saturation: saturation,
+		Transition: int(transition.Seconds() * 10),
+	})
+}
+
+func (c *Client) SetLightControlColorHex(ctx context.Context, root, id int, hex string, transition time.Duration) error {
+	return c.putLight(ctx, root, id, struct {
+		Hex        string `json:"5706"` // one of the gateway presets
+		Transition int    `json:"5712"` // tenths of a second
+	}{
+		Hex:        hex,
+		Transition: int(transition.Seconds() * 10),
+	})
+}
+
+// putLight sets light control properties of a device or group. Groups take
+// them at the top level, but devices only in the list of light controls, one
+// per bulb; they're applied to the first.
+func (c *Client) putLight(ctx context.Context, root, id int, request interface{}) error {
+	if root == RootDevices {
+		request = struct {
+			LightControl []interface{} `json:"3311"`
+		}{
+			LightControl: []interface{}{request},
+		}
+	}
+	return c.put(ctx, fmt.Sprintf("/%d/%d", root, id), request)
+}
+
+func (c *Client) get(ctx context.Context, path string, response interface{}) error {
+	msg, err := c.exchange(ctx, codes.GET, path, nil)
 	if err != nil {
 		return fmt.Errorf("error making Get request: %w", err)
 	}
 
-	if msg.Code() > 100 {
-		return fmt.Errorf("response code %d (%s)", msg.Code(), msg.Code().String())
+	if err := checkResponse(path, msg.Code()); err != nil {
+		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "### get(%s): %s\n", path, string(msg.Payload()))
-
 	if err := json.Unmarshal(msg.Payload(), response); err != nil {
 		return fmt.Errorf("error unmarshaling response: %w", err)
 	}
@@ -165,19 +357,58 @@ func (c *Client) get(path string, response interface{}) error {
 	return nil
 }
 
-func (c *Client) put(path string, request interface{}) error {
+func (c *Client) put(ctx context.Context, path string, request interface{}) error {
 	buf, err := json.Marshal(request)
 	if err != nil {
 		return fmt.Errorf("error marshaling request: %w", err)
 	}
 
-	msg, err := c.conn.Put(path, coap.AppJSON, bytes.NewReader(buf))
+	msg, err := c.exchange(ctx, codes.PUT, path, buf)
 	if err != nil {
 		return fmt.Errorf("error making Put request: %w", err)
 	}
 
-	if msg.Code() > 100 {
-		return fmt.Errorf("response code %d (%s)", msg.Code(), msg.Code().String())
+	if err := checkResponse(path, msg.Code()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, path string, request, response interface{}) error {
+	buf, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	msg, err := c.exchange(ctx, codes.POST, path, buf)
+	if err != nil {
+		return fmt.Errorf("error making Post request: %w", err)
+	}
+
+	if err := checkResponse(path, msg.Code()); err != nil {
+		return err
+	}
+
+	if response == nil || len(msg.Payload()) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(msg.Payload(), response); err != nil {
+		return fmt.Errorf("error unmarshaling response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) delete(ctx context.Context, path string) error {
+	msg, err := c.exchange(ctx, codes.DELETE, path, nil)
+	if err != nil {
+		return fmt.Errorf("error making Delete request: %w", err)
+	}
+
+	if err := checkResponse(path, msg.Code()); err != nil {
+		return err
 	}
 
 	return nil
@@ -201,6 +432,8 @@ type LightControl struct {
 	State         OnOff      `json:"5850"`
 	Dimmer        Percent255 `json:"5851"`
 	LightColorHex string     `json:"5706"`
+	Hue           int        `json:"5707"`
+	Saturation    int        `json:"5708"`
 	LightColorX   int        `json:"5709"`
 	LightColorY   int        `json:"5710"`
 	LightMireds   int        `json:"5711"`
@@ -211,6 +444,8 @@ type LightControlInput struct {
 	State         *OnOff      `json:"5850,omitempty"`
 	Dimmer        *Percent255 `json:"5851,omitempty"`
 	LightColorHex *string     `json:"5706,omitempty"`
+	Hue           *int        `json:"5707,omitempty"`
+	Saturation    *int        `json:"5708,omitempty"`
 	LightColorX   *int        `json:"5709,omitempty"`
 	LightColorY   *int        `json:"5710,omitempty"`
 	LightMireds   *int        `json:"5711,omitempty"`
@@ -258,6 +493,8 @@ func (d Device) Long() string {
 		fmt.Fprintf(&b, "Light control %d: State: %s\n", i+1, c.State)
 		fmt.Fprintf(&b, "Light control %d: Dimmer: %s\n", i+1, c.Dimmer)
 		fmt.Fprintf(&b, "Light control %d: Light color (hex): %s\n", i+1, c.LightColorHex)
+		fmt.Fprintf(&b, "Light control %d: Light color (hue): %d\n", i+1, c.Hue)
+		fmt.Fprintf(&b, "Light control %d: Light color (saturation): %d\n", i+1, c.Saturation)
 		fmt.Fprintf(&b, "Light control %d: Light color (X): %d\n", i+1, c.LightColorX)
 		fmt.Fprintf(&b, "Light control %d: Light color (Y): %d\n", i+1, c.LightColorY)
 		fmt.Fprintf(&b, "Light control %d: Light mireds: %d\n", i+1, c.LightMireds)
@@ -271,15 +508,38 @@ func (d Device) Long() string {
 
 type Group struct {
 	Resource
-	State         OnOff      `json:"5850"`
-	Dimmer        Percent255 `json:"5851"`
-	LightColorHex string     `json:"5706"`
-	MoodID        int        `json:"9039"`
-	GroupMembers  struct {
-		HSLink struct {
-			IDs []int `json:"9003"`
-		} `json:"15002"`
-	} `json:"9018"`
+	State         OnOff        `json:"5850"`
+	Dimmer        Percent255   `json:"5851"`
+	LightColorHex string       `json:"5706"`
+	MoodID        int          `json:"9039"`
+	GroupMembers  GroupMembers `json:"9018"`
+}
+
+type GroupMembers struct {
+	HSLink struct {
+		IDs []int `json:"9003"`
+	} `json:"15002"`
+}
+
+// NewGroupMembers returns group members with the given device IDs.
+func NewGroupMembers(deviceIDs ...int) GroupMembers {
+	var members GroupMembers
+	members.HSLink.IDs = append([]int{}, deviceIDs...)
+	return members
+}
+
+func (m GroupMembers) Contains(deviceID int) bool {
+	for _, id := range m.HSLink.IDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+type GroupInput struct {
+	Name    *string       `json:"9001,omitempty"`
+	Members *GroupMembers `json:"9018,omitempty"`
 }
 
 func (g Group) Short() string {
@@ -319,8 +579,13 @@ type SetGroupProperties struct {
 
 type Percent255 int
 
+// Percent converts the value to 0..100, rounding to the nearest percent.
+func (p Percent255) Percent() int {
+	return int(math.Round(100 * (float64(p) / float64(255))))
+}
+
 func (p Percent255) String() string {
-	return fmt.Sprintf("%d%%", int(100*(float64(p)/float64(255))))
+	return fmt.Sprintf("%d%%", p.Percent())
 }
 
 type Percent100 int
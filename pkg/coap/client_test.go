@@ -0,0 +1,150 @@
+package coap_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+const securityCode = "0123456789abcdef"
+
+func TestClientRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New(securityCode)
+	var (
+		ceiling = g.AddDevice(testLight("Kitchen ceiling"))
+		counter = g.AddDevice(testLight("Kitchen counter"))
+		kitchen = g.AddGroup(coap.Group{Resource: coap.Resource{Name: "Kitchen"}, GroupMembers: coap.NewGroupMembers(ceiling, counter)})
+	)
+	for _, name := range []string{"EVERYDAY", "RELAX"} {
+		if _, err := g.AddMood(kitchen, coap.Mood{Resource: coap.Resource{Name: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// Provision an identity with the security code, like lightctl auth.
+	var traces []coap.Trace
+	authClient, err := coap.NewClient(ctx, "udp", g.Addr().String(), fakegateway.AuthIdentity, securityCode, coap.WithTracer(func(t coap.Trace) {
+		traces = append(traces, t)
+	}))
+	if err != nil {
+		t.Fatalf("NewClient with security code: %v", err)
+	}
+	psk, err := authClient.Auth(ctx, "test")
+	authClient.Close()
+	if err != nil {
+		t.Fatalf("Auth: %v", err)
+	}
+	if psk == "" {
+		t.Fatal("Auth: empty PSK")
+	}
+	var redacted bool
+	for _, trace := range traces {
+		s := trace.String()
+		if strings.Contains(s, psk) {
+			t.Errorf("trace contains the PSK: %s", s)
+		}
+		redacted = redacted || strings.Contains(s, "REDACTED")
+	}
+	if !redacted {
+		t.Errorf("no trace of the auth response")
+	}
+
+	client, err := coap.NewClient(ctx, "udp", g.Addr().String(), "test", psk)
+	if err != nil {
+		t.Fatalf("NewClient with PSK: %v", err)
+	}
+	defer client.Close()
+
+	devices, err := client.ListDevices(ctx)
+	if err != nil {
+		t.Fatalf("ListDevices: %v", err)
+	}
+	if want, have := 2, len(devices); want != have {
+		t.Fatalf("ListDevices: want %d devices, have %d", want, have)
+	}
+	if want, have := "Kitchen ceiling", devices[0].Name; want != have {
+		t.Errorf("ListDevices: want first device %q, have %q", want, have)
+	}
+
+	d, err := client.GetDevice(ctx, counter)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if want, have := "Kitchen counter", d.Name; want != have {
+		t.Errorf("GetDevice: want %q, have %q", want, have)
+	}
+
+	moods, err := client.ListMoods(ctx, kitchen)
+	if err != nil {
+		t.Fatalf("ListMoods: %v", err)
+	}
+	if want, have := 2, len(moods); want != have {
+		t.Errorf("ListMoods: want %d moods, have %d", want, have)
+	}
+
+	var (
+		state  = coap.OnOff(0)
+		dimmer = coap.Percent255(127)
+	)
+	if err := client.SetLightControl(ctx, coap.RootDevices, ceiling, coap.LightControlInput{State: &state, Dimmer: &dimmer}); err != nil {
+		t.Fatalf("SetLightControl: %v", err)
+	}
+	d, ok := g.Device(ceiling)
+	if !ok {
+		t.Fatal("device disappeared from the gateway")
+	}
+	if lc := d.LightControl[0]; lc.State != state || lc.Dimmer != dimmer {
+		t.Errorf("SetLightControl: want state %d dimmer %d, have state %d dimmer %d", state, dimmer, lc.State, lc.Dimmer)
+	}
+
+	if _, err := client.GetDevice(ctx, 12345); !errors.Is(err, coap.ErrNotFound) {
+		t.Errorf("GetDevice of unknown ID: want %v, have %v", coap.ErrNotFound, err)
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New(securityCode)
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// The security code identity may only authenticate.
+	client, err := coap.NewClient(ctx, "udp", g.Addr().String(), fakegateway.AuthIdentity, securityCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.ListDevices(ctx); !errors.Is(err, coap.ErrUnauthorized) {
+		t.Errorf("ListDevices: want %v, have %v", coap.ErrUnauthorized, err)
+	}
+}
+
+func testLight(name string) coap.Device {
+	var d coap.Device
+	d.Name = name
+	d.Reachable = 1
+	d.LightControl = []coap.LightControl{{
+		State:         1,
+		Dimmer:        254,
+		LightColorHex: "f1e0b5",
+		LightMireds:   370,
+	}}
+	return d
+}
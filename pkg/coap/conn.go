@@ -0,0 +1,267 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+	"github.com/pion/dtls/v2"
+)
+
+const (
+	defaultDialTimeout    = 3 * time.Second
+	defaultRequestTimeout = 10 * time.Second
+	defaultKeepAlive      = 30 * time.Second
+)
+
+// ErrClosed is returned by requests made after the client is closed.
+var ErrClosed = errors.New("client closed")
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithDialTimeout sets the timeout for establishing a DTLS session with the
+// gateway, including the handshake. The default is 3s.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) { c.dialTimeout = d }
+}
+
+// WithRequestTimeout sets the timeout for a single request, from sending it to
+// receiving the response. The default is 10s.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(c *Client) { c.requestTimeout = d }
+}
+
+// WithKeepAlive sets how quickly a lost session is detected. The client pings
+// the gateway several times within that period, and drops the session if the
+// pings go unanswered. The default is 30s. Zero disables keepalives, so a lost
+// session is only detected by a failed request.
+func WithKeepAlive(d time.Duration) Option {
+	return func(c *Client) { c.keepAlive = d }
+}
+
+// session is a single DTLS session with the gateway. Lost is closed when the
+// session ends, for whatever reason.
+type session struct {
+	conn *coap.ClientConn
+	lost chan struct{}
+}
+
+func (s *session) alive() bool {
+	select {
+	case <-s.lost:
+		return false
+	default:
+		return true
+	}
+}
+
+// connect returns the current session, establishing a new one if there's none,
+// or if the previous one was lost. The context bounds the dial, together with
+// the dial timeout.
+func (c *Client) connect(ctx context.Context) (*session, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	if c.session != nil {
+		if c.session.alive() {
+			return c.session, nil
+		}
+		c.session.conn.Close()
+		c.session = nil
+	}
+
+	var (
+		lost     = make(chan struct{})
+		lostOnce sync.Once
+	)
+	// The handshake has its own timeout, which outlasts the dial timeout, so a
+	// slow handshake is always reported as a context.DeadlineExceeded.
+	config := *c.dtlsConfig
+	config.ConnectTimeout = dtls.ConnectTimeoutOption(c.dialTimeout + time.Second)
+	client := coap.Client{
+		Net:         c.network,
+		DTLSConfig:  &config,
+		DialTimeout: c.dialTimeout,
+		NotifySessionEndFunc: func(error) {
+			lostOnce.Do(func() { close(lost) })
+		},
+	}
+	if c.keepAlive > 0 {
+		keepAlive, err := coap.MakeKeepAlive(c.keepAlive)
+		if err != nil {
+			return nil, err
+		}
+		client.KeepAlive = keepAlive
+	}
+
+	// The DTLS handshake ignores the context, so the dial is abandoned rather
+	// than canceled when the context is done.
+	ctx, cancel := context.WithTimeout(ctx, c.dialTimeout)
+	defer cancel()
+	type result struct {
+		conn *coap.ClientConn
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := client.DialWithContext(ctx, c.address)
+		results <- result{conn, err}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			return nil, r.err
+		}
+		c.session = &session{conn: r.conn, lost: lost}
+		return c.session, nil
+
+	case <-ctx.Done():
+		go func() {
+			if r := <-results; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// disconnect tears down a session that's assumed to be broken, so that the
+// next request establishes a new one.
+func (c *Client) disconnect(s *session) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.session == s {
+		c.session = nil
+	}
+	s.conn.Close()
+}
+
+// exchange performs a request over the current session. GET and PUT requests
+// are idempotent, and retried according to the retry policy. DELETE requests
+// aren't retried: if the first attempt succeeded, but its response was lost,
+// the retry would fail with 4.04 Not Found. A response with an error code is
+// returned as is, and left for the caller to check.
+func (c *Client) exchange(ctx context.Context, method codes.Code, path string, body []byte) (coap.Message, error) {
+	attempts := 1
+	if method == codes.GET || method == codes.PUT {
+		attempts = c.retryPolicy.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		msg, err := c.attempt(ctx, attempt, method, path, body)
+
+		failure := err
+		if failure == nil {
+			failure = checkResponse(path, msg.Code())
+		}
+		if failure == nil || attempt >= attempts || ctx.Err() != nil || !c.retryPolicy.retryable(failure) {
+			return msg, err
+		}
+
+		if err := c.retryPolicy.wait(ctx, attempt); err != nil {
+			return nil, timeout(path, err)
+		}
+	}
+}
+
+// attempt performs a request once, and traces it. If the request fails, the
+// session is torn down, so that the next attempt establishes a new one. A
+// request that fails because the context is done leaves the session alone.
+func (c *Client) attempt(ctx context.Context, attempt int, method codes.Code, path string, body []byte) (msg coap.Message, err error) {
+	if c.tracer != nil {
+		defer func(start time.Time) {
+			t := Trace{
+				Method:   method,
+				Path:     path,
+				Attempt:  attempt,
+				Request:  body,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+			if msg != nil {
+				t.Code, t.Response = msg.Code(), redactPSK(path, msg.Payload())
+			}
+			c.tracer(t)
+		}(time.Now())
+	}
+
+	s, err := c.connect(ctx)
+	if err != nil {
+		return nil, timeout(path, err)
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	msg, err = send(requestCtx, s.conn, method, path, body)
+	cancel()
+	switch {
+	case err == nil:
+		return msg, nil
+	case ctx.Err() != nil:
+		return nil, timeout(path, ctx.Err())
+	default:
+		c.disconnect(s)
+		return nil, timeout(path, err)
+	}
+}
+
+func send(ctx context.Context, conn *coap.ClientConn, method codes.Code, path string, body []byte) (coap.Message, error) {
+	switch method {
+	case codes.GET:
+		return conn.GetWithContext(ctx, path)
+	case codes.PUT:
+		return conn.PutWithContext(ctx, path, coap.AppJSON, bytes.NewReader(body))
+	case codes.POST:
+		return conn.PostWithContext(ctx, path, coap.AppJSON, bytes.NewReader(body))
+	case codes.DELETE:
+		return conn.DeleteWithContext(ctx, path)
+	default:
+		return nil, fmt.Errorf("unsupported method %s", method)
+	}
+}
+
+// Close tears down the session with the gateway. Subsequent requests return
+// ErrClosed.
+func (c *Client) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.closed = true
+	if c.session == nil {
+		return nil
+	}
+	err := c.session.conn.Close()
+	c.session = nil
+	return err
+}
+
+func dtlsNetwork(network string) string {
+	if !strings.HasSuffix(network, "-dtls") {
+		network += "-dtls"
+	}
+	return network
+}
+
+func dtlsConfig(username, psk string) *dtls.Config {
+	return &dtls.Config{
+		PSK:             func(hint []byte) ([]byte, error) { return []byte(psk), nil },
+		PSKIdentityHint: []byte(username),
+		CipherSuites: []dtls.CipherSuiteID{
+			dtls.TLS_PSK_WITH_AES_128_CCM,
+			dtls.TLS_PSK_WITH_AES_128_CCM_8,
+			dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-ocf/go-coap/codes"
+)
+
+// Errors that classify failed requests. Use errors.Is to test for them; use
+// errors.As with *ResponseError or *TimeoutError for the details.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrBadRequest         = errors.New("bad request")
+	ErrServiceUnavailable = errors.New("service unavailable")
+	ErrTimeout            = errors.New("timeout")
+)
+
+// ResponseError is returned when the gateway responds to a request with an
+// error code. It wraps the matching error above, if there is one.
+type ResponseError struct {
+	Path string
+	Code codes.Code
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s: response code %d (%s)", e.Path, e.Code, e.Code.String())
+}
+
+func (e *ResponseError) Unwrap() error {
+	switch e.Code {
+	case codes.NotFound:
+		return ErrNotFound
+	case codes.Unauthorized, codes.Forbidden:
+		return ErrUnauthorized
+	case codes.BadRequest, codes.BadOption, codes.MethodNotAllowed, codes.UnsupportedMediaType:
+		return ErrBadRequest
+	case codes.ServiceUnavailable:
+		return ErrServiceUnavailable
+	case codes.GatewayTimeout:
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
+
+// TimeoutError is returned when the gateway doesn't complete a request, or the
+// handshake for a new session, in time. It matches ErrTimeout, and wraps the
+// underlying context.DeadlineExceeded.
+type TimeoutError struct {
+	Path string // empty for the handshake in NewClient
+	Err  error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Path == "" {
+		return "timed out connecting to gateway"
+	}
+	return fmt.Sprintf("%s: timed out", e.Path)
+}
+
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// Timeout implements net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary implements net.Error.
+func (e *TimeoutError) Temporary() bool { return true }
+
+// checkResponse returns a *ResponseError if the response has an error code.
+func checkResponse(path string, code codes.Code) error {
+	if code > 100 {
+		return &ResponseError{Path: path, Code: code}
+	}
+	return nil
+}
+
+// timeout wraps deadline errors in a *TimeoutError.
+func timeout(path string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Path: path, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (c *Client) GetGatewayInfo(ctx context.Context) (g GatewayInfo, err error) {
+	err = c.get(ctx, fmt.Sprintf("/%d/%d", RootGateway, gatewayDetails), &g)
+	return g, err
+}
+
+// SetGatewayNTPServer changes the NTP server the gateway syncs its clock with.
+func (c *Client) SetGatewayNTPServer(ctx context.Context, server string) error {
+	return c.put(ctx, fmt.Sprintf("/%d/%d", RootGateway, gatewayDetails), struct {
+		NTPServer string `json:"9023"`
+	}{
+		NTPServer: server,
+	})
+}
+
+// RebootGateway restarts the gateway. It's unavailable for a minute or so
+// afterwards, and the client must reconnect.
+func (c *Client) RebootGateway(ctx context.Context) error {
+	return c.post(ctx, fmt.Sprintf("/%d/%d", RootGateway, gatewayReboot), struct{}{}, nil)
+}
+
+// CheckGatewayFirmware makes the gateway check for, and download, a firmware
+// update. Progress is reported by GatewayInfo.
+func (c *Client) CheckGatewayFirmware(ctx context.Context) error {
+	return c.post(ctx, fmt.Sprintf("/%d/%d", RootGateway, gatewayUpdateFirmware), struct{}{}, nil)
+}
+
+const (
+	gatewayAuth           = 9063
+	gatewayReboot         = 9030
+	gatewayUpdateFirmware = 9034
+	gatewayDetails        = 15012
+)
+
+//
+//
+//
+
+type GatewayInfo struct {
+	ID                   string    `json:"9081"`
+	Firmware             string    `json:"9029"`
+	NTPServer            string    `json:"9023"`
+	CurrentTime          Timestamp `json:"9059"`
+	CurrentTimeISO8601   string    `json:"9060"`
+	FirstSetup           Timestamp `json:"9069"`
+	CommissioningMode    int       `json:"9061"`
+	OTAUpdateState       int       `json:"9054"`
+	OTAType              int       `json:"9066"`
+	UpdateProgress       int       `json:"9055"`
+	UpdateDetailsURL     string    `json:"9056"`
+	HomeKitID            string    `json:"9083"`
+	AlexaPairStatus      YesNo     `json:"9093"`
+	GoogleHomePairStatus YesNo     `json:"9105"`
+}
+
+func (g GatewayInfo) Long() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ID: %s\n", g.ID)
+	fmt.Fprintf(&b, "Firmware: %s\n", g.Firmware)
+	fmt.Fprintf(&b, "NTP server: %s\n", g.NTPServer)
+	fmt.Fprintf(&b, "Current time: %s\n", time.Unix(int64(g.CurrentTime), 0).Format(time.RubyDate))
+	fmt.Fprintf(&b, "First setup: %s\n", g.FirstSetup)
+	fmt.Fprintf(&b, "Commissioning mode: %d\n", g.CommissioningMode)
+	fmt.Fprintf(&b, "OTA update state: %d\n", g.OTAUpdateState)
+	fmt.Fprintf(&b, "OTA type: %d\n", g.OTAType)
+	fmt.Fprintf(&b, "Update progress: %d%%\n", g.UpdateProgress)
+	fmt.Fprintf(&b, "Update details URL: %s\n", g.UpdateDetailsURL)
+	fmt.Fprintf(&b, "HomeKit ID: %s\n", g.HomeKitID)
+	fmt.Fprintf(&b, "Alexa paired: %s\n", g.AlexaPairStatus)
+	fmt.Fprintf(&b, "Google Home paired: %s\n", g.GoogleHomePairStatus)
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,91 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultConcurrency is deliberately low: the gateway is a small device, and
+// starts answering 5.03 Service Unavailable when it's pushed too hard.
+const defaultConcurrency = 4
+
+// WithConcurrency sets the maximum number of requests the list methods, e.g.
+// ListDevices, make at once, to fetch the individual resources. The default is
+// 4, and values below 1 are treated as 1.
+func WithConcurrency(n int) Option {
+	return func(c *Client) {
+		if n < 1 {
+			n = 1
+		}
+		c.concurrency = n
+	}
+}
+
+// PartialError is returned by ListDevices, ListGroups, ListMoods, and
+// ListSmartTasks when some of the individual resources couldn't be fetched.
+// The resources that could be are returned alongside it, in order.
+type PartialError struct {
+	Kind   string        // "device", "group", "mood", or "task"
+	Total  int           // number of resources listed by the gateway
+	Errors map[int]error // resource ID: error
+}
+
+func (e *PartialError) Error() string {
+	ids := make([]int, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	details := make([]string, len(ids))
+	for i, id := range ids {
+		details[i] = fmt.Sprintf("%s %d: %v", e.Kind, id, e.Errors[id])
+	}
+
+	return fmt.Sprintf("couldn't get %d of %d %ss: %s", len(ids), e.Total, e.Kind, strings.Join(details, "; "))
+}
+
+// fetchEach calls fetch for the indexes 0 to n-1, with at most concurrency
+// calls in flight, and returns the error of each call.
+func (c *Client) fetchEach(ctx context.Context, n int, fetch func(ctx context.Context, i int) error) []error {
+	var (
+		errs      = make([]error, n)
+		semaphore = make(chan struct{}, c.concurrency)
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = fetch(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// partialError returns a PartialError for the failed fetches, or nil if none
+// of them failed.
+func partialError(kind string, ids []int, errs []error) error {
+	failed := map[int]error{}
+	for i, err := range errs {
+		if err != nil {
+			failed[ids[i]] = err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &PartialError{Kind: kind, Total: len(ids), Errors: failed}
+}
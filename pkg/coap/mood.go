@@ -0,0 +1,117 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (c *Client) GetMood(ctx context.Context, groupID, moodID int) (m Mood, err error) {
+	err = c.get(ctx, fmt.Sprintf("/%d/%d/%d", RootMoods, groupID, moodID), &m)
+	return m, err
+}
+
+// ListMoods returns the moods of the group, with the same semantics as
+// ListDevices.
+func (c *Client) ListMoods(ctx context.Context, groupID int) ([]Mood, error) {
+	var ids []int
+	if err := c.get(ctx, fmt.Sprintf("/%d/%d", RootMoods, groupID), &ids); err != nil {
+		return nil, fmt.Errorf("error listing mood IDs: %w", err)
+	}
+
+	fetched := make([]Mood, len(ids))
+	errs := c.fetchEach(ctx, len(ids), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = c.GetMood(ctx, groupID, ids[i])
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var moods []Mood
+	for i, m := range fetched {
+		if errs[i] == nil {
+			moods = append(moods, m)
+		}
+	}
+
+	return moods, partialError("mood", ids, errs)
+}
+
+// ActivateMood applies a mood to its group, which also turns the group on.
+func (c *Client) ActivateMood(ctx context.Context, groupID, moodID int) error {
+	return c.put(ctx, fmt.Sprintf("/%d/%d", RootGroups, groupID), struct {
+		State  int `json:"5850"`
+		MoodID int `json:"9039"`
+	}{
+		State:  1,
+		MoodID: moodID,
+	})
+}
+
+// CreateMood creates a new mood for the group, and returns its ID.
+func (c *Client) CreateMood(ctx context.Context, groupID int, input MoodInput) (int, error) {
+	var response Resource
+	if err := c.post(ctx, fmt.Sprintf("/%d/%d", RootMoods, groupID), input, &response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+func (c *Client) UpdateMood(ctx context.Context, groupID, moodID int, input MoodInput) error {
+	return c.put(ctx, fmt.Sprintf("/%d/%d/%d", RootMoods, groupID, moodID), input)
+}
+
+func (c *Client) DeleteMood(ctx context.Context, groupID, moodID int) error {
+	return c.delete(ctx, fmt.Sprintf("/%d/%d/%d", RootMoods, groupID, moodID))
+}
+
+//
+//
+//
+
+type Mood struct {
+	Resource
+	Predefined YesNo       `json:"9068"`
+	Index      int         `json:"9057"`
+	Lights     []MoodLight `json:"15013"`
+}
+
+type MoodLight struct {
+	ID            int        `json:"9003"`
+	State         OnOff      `json:"5850"`
+	Dimmer        Percent255 `json:"5851"`
+	LightColorHex string     `json:"5706,omitempty"`
+	LightColorX   int        `json:"5709,omitempty"`
+	LightColorY   int        `json:"5710,omitempty"`
+	LightMireds   int        `json:"5711,omitempty"`
+}
+
+type MoodInput struct {
+	Name   *string     `json:"9001,omitempty"`
+	Lights []MoodLight `json:"15013,omitempty"`
+}
+
+func (m Mood) Short() string {
+	n := len(m.Lights)
+	return fmt.Sprintf("%d: %s - %d light%s", m.ID, m.Name, n, plural(n))
+}
+
+func (m Mood) Long() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Name: %s\n", m.Name)
+	fmt.Fprintf(&b, "Created at: %s\n", m.CreatedAt)
+	fmt.Fprintf(&b, "ID: %d\n", m.ID)
+	fmt.Fprintf(&b, "Predefined: %s\n", m.Predefined)
+	fmt.Fprintf(&b, "Light count: %d\n", len(m.Lights))
+	for i, l := range m.Lights {
+		fmt.Fprintf(&b, "Light %d: ID: %d\n", i+1, l.ID)
+		fmt.Fprintf(&b, "Light %d: State: %s\n", i+1, l.State)
+		fmt.Fprintf(&b, "Light %d: Dimmer: %s\n", i+1, l.Dimmer)
+		fmt.Fprintf(&b, "Light %d: Light color (hex): %s\n", i+1, l.LightColorHex)
+		fmt.Fprintf(&b, "Light %d: Light color (X): %d\n", i+1, l.LightColorX)
+		fmt.Fprintf(&b, "Light %d: Light color (Y): %d\n", i+1, l.LightColorY)
+		fmt.Fprintf(&b, "Light %d: Light mireds: %d\n", i+1, l.LightMireds)
+	}
+	return strings.TrimSpace(b.String())
+}
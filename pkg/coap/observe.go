@@ -0,0 +1,224 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+)
+
+// defaultMaxAge is the CoAP default for the Max-Age option (RFC 7252 5.10.5).
+// Observations that receive no notification within their max-age, plus some
+// grace, are considered lapsed and are re-registered (RFC 7641 3.3.1). If that
+// fails, e.g. because the gateway is unreachable, it's retried after
+// reregisterInterval.
+const (
+	defaultMaxAge      = 60 * time.Second
+	maxAgeGrace        = 5 * time.Second
+	reregisterInterval = 5 * time.Second
+)
+
+// ObserveDevice streams the state of the device with the given ID. The current
+// state is sent first, followed by every change. The observation survives lost
+// sessions. The channel is closed when the context is canceled, when the client
+// is closed, or when the gateway stops serving the device. Notifications that
+// can't be decoded are skipped, and reported to the tracer, if any.
+func (c *Client) ObserveDevice(ctx context.Context, id int) (<-chan Device, error) {
+	path := fmt.Sprintf("/%d/%d", RootDevices, id)
+	payloads, err := c.observe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(chan Device)
+	go func() {
+		defer close(devices)
+		for buf := range payloads {
+			var d Device
+			if err := json.Unmarshal(buf, &d); err != nil {
+				c.traceNotification(path, buf, err)
+				continue
+			}
+			select {
+			case devices <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return devices, nil
+}
+
+// ObserveGroup streams the state of the group with the given ID, with the same
+// semantics as ObserveDevice.
+func (c *Client) ObserveGroup(ctx context.Context, id int) (<-chan Group, error) {
+	path := fmt.Sprintf("/%d/%d", RootGroups, id)
+	payloads, err := c.observe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(chan Group)
+	go func() {
+		defer close(groups)
+		for buf := range payloads {
+			var g Group
+			if err := json.Unmarshal(buf, &g); err != nil {
+				c.traceNotification(path, buf, err)
+				continue
+			}
+			select {
+			case groups <- g:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return groups, nil
+}
+
+// observe registers an observation on path, and returns a channel of distinct
+// payloads. The observation is re-registered whenever it lapses or its session
+// is lost, and canceled when the context is canceled.
+//
+// Notifications are delivered from the handler of the session, which serves
+// all requests. Once the observation has ended, notifications that are still
+// in flight are dropped, rather than blocking the handler.
+func (c *Client) observe(ctx context.Context, path string) (<-chan []byte, error) {
+	var (
+		notifications = make(chan coap.Message)
+		done          = make(chan struct{})
+	)
+	register := func() (*coap.Observation, <-chan struct{}, error) {
+		s, err := c.connect(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		obs, err := s.conn.ObserveWithContext(ctx, path, func(req *coap.Request) {
+			select {
+			case notifications <- req.Msg:
+			case <-done:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			c.disconnect(s)
+			return nil, nil, err
+		}
+		return obs, s.lost, nil
+	}
+
+	obs, lost, err := register()
+	if err != nil {
+		close(done)
+		return nil, fmt.Errorf("error registering observation: %w", err)
+	}
+
+	payloads := make(chan []byte)
+	go func() {
+		defer close(payloads)
+		defer close(done)
+
+		var (
+			last  []byte
+			lapse = time.NewTimer(defaultMaxAge + maxAgeGrace)
+		)
+		defer lapse.Stop()
+		defer func() {
+			if obs != nil {
+				obs.Cancel()
+			}
+		}()
+
+		for {
+			select {
+			case msg := <-notifications:
+				if msg.Code() > 100 {
+					return
+				}
+
+				if !lapse.Stop() {
+					select {
+					case <-lapse.C:
+					default:
+					}
+				}
+				lapse.Reset(maxAge(msg) + maxAgeGrace)
+
+				if last != nil && bytes.Equal(msg.Payload(), last) {
+					continue
+				}
+				last = append([]byte(nil), msg.Payload()...)
+
+				select {
+				case payloads <- last:
+				case <-ctx.Done():
+					return
+				}
+
+			case <-lost:
+				// The session is gone, and the observation with it. Re-register
+				// right away, over a new session.
+				if !lapse.Stop() {
+					select {
+					case <-lapse.C:
+					default:
+					}
+				}
+				lapse.Reset(0)
+				lost = nil
+
+			case <-lapse.C:
+				if obs != nil {
+					obs.Cancel()
+				}
+				next, nextLost, err := register()
+				switch {
+				case errors.Is(err, ErrClosed):
+					obs = nil
+					return
+				case err != nil:
+					obs, lost = nil, nil
+					lapse.Reset(reregisterInterval)
+				default:
+					obs, lost = next, nextLost
+					lapse.Reset(defaultMaxAge + maxAgeGrace)
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return payloads, nil
+}
+
+// traceNotification reports a notification that couldn't be decoded, and is
+// therefore dropped, to the tracer.
+func (c *Client) traceNotification(path string, payload []byte, err error) {
+	if c.tracer == nil {
+		return
+	}
+	c.tracer(Trace{
+		Method:   codes.GET,
+		Path:     path,
+		Code:     codes.Content,
+		Response: payload,
+		Err:      fmt.Errorf("invalid notification: %w", err),
+	})
+}
+
+func maxAge(msg coap.Message) time.Duration {
+	if seconds, ok := msg.Option(coap.MaxAge).(uint32); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultMaxAge
+}
@@ -0,0 +1,106 @@
+package coap_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestObserveDevice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New(securityCode)
+	id := g.AddDevice(testLight("Kitchen ceiling"))
+	g.AddIdentity("test", "secret")
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	client, err := coap.NewClient(ctx, "udp", g.Addr().String(), "test", "secret", coap.WithKeepAlive(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	devices, err := client.ObserveDevice(ctx, id)
+	if err != nil {
+		t.Fatalf("ObserveDevice: %v", err)
+	}
+
+	rename := func(name string) {
+		t.Helper()
+		d, _ := g.Device(id)
+		d.Name = name
+		if err := g.UpdateDevice(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func(want string) {
+		t.Helper()
+		select {
+		case d, ok := <-devices:
+			if !ok {
+				t.Fatalf("want %q, have closed stream", want)
+			}
+			if want != d.Name {
+				t.Fatalf("want %q, have %q", want, d.Name)
+			}
+		case <-ctx.Done():
+			t.Fatalf("want %q, have nothing", want)
+		}
+	}
+
+	// The current state, then a notification.
+	next("Kitchen ceiling")
+	rename("Kitchen counter")
+	next("Kitchen counter")
+
+	// The gateway forgets the session, and the observation with it. The
+	// client re-registers over a new session, and gets the current state.
+	g.DropSessions()
+	rename("Hallway")
+	next("Hallway")
+	rename("Porch")
+	next("Porch")
+
+	// An error response ends the stream.
+	if err := client.RemoveDevice(ctx, id); err != nil {
+		t.Fatalf("RemoveDevice: %v", err)
+	}
+	select {
+	case d, ok := <-devices:
+		if ok {
+			t.Fatalf("after RemoveDevice: want closed stream, have %+v", d)
+		}
+	case <-ctx.Done():
+		t.Fatal("after RemoveDevice: stream wasn't closed")
+	}
+
+	// The session outlives the observation.
+	d := testLight("Kitchen ceiling")
+	d.ID = id
+	g.AddDevice(d)
+	rename("Hallway")
+	if _, err := client.GetDevice(ctx, id); err != nil {
+		t.Fatalf("GetDevice after the stream ended: %v", err)
+	}
+
+	// Observing an unknown device ends right away.
+	unknown, err := client.ObserveDevice(ctx, 12345)
+	if err != nil {
+		t.Fatalf("ObserveDevice of unknown ID: %v", err)
+	}
+	select {
+	case d, ok := <-unknown:
+		if ok {
+			t.Errorf("ObserveDevice of unknown ID: want closed stream, have %+v", d)
+		}
+	case <-ctx.Done():
+		t.Error("ObserveDevice of unknown ID: stream wasn't closed")
+	}
+}
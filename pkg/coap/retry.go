@@ -0,0 +1,91 @@
+package coap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how idempotent requests, i.e. GETs and PUTs, are
+// retried when they fail. Other requests are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one. Values
+	// below 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry. It doubles with each
+	// further retry, up to MaxBackoff. Each wait is randomly shortened by up to
+	// half, so that concurrent requests don't retry in lockstep.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Retryable reports whether a failed attempt should be retried. Response
+	// codes are passed as a *ResponseError. If it's nil, DefaultRetryable is
+	// used.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is used by clients unless WithRetryPolicy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     4 * time.Second,
+	Retryable:      DefaultRetryable,
+}
+
+// WithRetryPolicy sets the retry policy. The default is DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retryPolicy = p }
+}
+
+// DefaultRetryable retries the failures that the gateway typically recovers
+// from by itself: 5.03 Service Unavailable, timeouts, and lost sessions.
+func DefaultRetryable(err error) bool {
+	var response *ResponseError
+	switch {
+	case errors.Is(err, ErrServiceUnavailable), errors.Is(err, ErrTimeout):
+		return true
+	case errors.As(err, &response):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrClosed):
+		return false
+	default:
+		return true
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return DefaultRetryable(err)
+	}
+	return p.Retryable(err)
+}
+
+// backoff returns how long to wait after the given failed attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// wait sleeps for the backoff after the given failed attempt, or until the
+// context is done.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	t := time.NewTimer(p.backoff(attempt))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,223 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (c *Client) GetSmartTask(ctx context.Context, id int) (t SmartTask, err error) {
+	err = c.get(ctx, fmt.Sprintf("/%d/%d", RootSmartTasks, id), &t)
+	return t, err
+}
+
+// ListSmartTasks returns all smart tasks, with the same semantics as
+// ListDevices.
+func (c *Client) ListSmartTasks(ctx context.Context) ([]SmartTask, error) {
+	var ids []int
+	if err := c.get(ctx, fmt.Sprintf("/%d", RootSmartTasks), &ids); err != nil {
+		return nil, fmt.Errorf("error listing smart task IDs: %w", err)
+	}
+
+	fetched := make([]SmartTask, len(ids))
+	errs := c.fetchEach(ctx, len(ids), func(ctx context.Context, i int) (err error) {
+		fetched[i], err = c.GetSmartTask(ctx, ids[i])
+		return err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tasks []SmartTask
+	for i, t := range fetched {
+		if errs[i] == nil {
+			tasks = append(tasks, t)
+		}
+	}
+
+	return tasks, partialError("task", ids, errs)
+}
+
+// CreateSmartTask creates a new smart task, and returns its ID.
+func (c *Client) CreateSmartTask(ctx context.Context, input SmartTaskInput) (int, error) {
+	var response Resource
+	if err := c.post(ctx, fmt.Sprintf("/%d", RootSmartTasks), input, &response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
+
+func (c *Client) UpdateSmartTask(ctx context.Context, id int, input SmartTaskInput) error {
+	return c.put(ctx, fmt.Sprintf("/%d/%d", RootSmartTasks, id), input)
+}
+
+func (c *Client) SetSmartTaskEnabled(ctx context.Context, id int, enabled bool) error {
+	var yn YesNo
+	if enabled {
+		yn = 1
+	}
+	return c.UpdateSmartTask(ctx, id, SmartTaskInput{Enabled: &yn})
+}
+
+func (c *Client) DeleteSmartTask(ctx context.Context, id int) error {
+	return c.delete(ctx, fmt.Sprintf("/%d/%d", RootSmartTasks, id))
+}
+
+//
+//
+//
+
+// SmartTask is a schedule stored and run by the gateway. Trigger times are in
+// UTC.
+type SmartTask struct {
+	Resource
+	Type        TaskType        `json:"9040"`
+	Enabled     YesNo           `json:"5850"`
+	RepeatDays  Weekdays        `json:"9041"`
+	StartAction TaskStartAction `json:"9042"`
+	Triggers    []TaskTrigger   `json:"9044"`
+}
+
+type TaskStartAction struct {
+	State  OnOff       `json:"5850"`
+	Lights []TaskLight `json:"15013"`
+}
+
+type TaskLight struct {
+	ID         int        `json:"9003"`
+	Dimmer     Percent255 `json:"5851"`
+	Transition int        `json:"5712"` // tenths of a second
+}
+
+// TaskTrigger is the time of day a smart task runs. The end time is only used
+// by not home tasks, which run randomly within the interval.
+type TaskTrigger struct {
+	StartHour   int  `json:"9046"`
+	StartMinute int  `json:"9047"`
+	EndHour     *int `json:"9048,omitempty"`
+	EndMinute   *int `json:"9049,omitempty"`
+}
+
+type SmartTaskInput struct {
+	Type        TaskType         `json:"9040,omitempty"`
+	Enabled     *YesNo           `json:"5850,omitempty"`
+	RepeatDays  *Weekdays        `json:"9041,omitempty"`
+	StartAction *TaskStartAction `json:"9042,omitempty"`
+	Triggers    []TaskTrigger    `json:"9044,omitempty"`
+}
+
+func (t SmartTask) Short() string {
+	var at string
+	if len(t.Triggers) > 0 {
+		at = " at " + t.Triggers[0].String()
+	}
+	enabled := "enabled"
+	if t.Enabled == 0 {
+		enabled = "disabled"
+	}
+	return fmt.Sprintf("%d: %s%s, %s (%s)", t.ID, t.Type, at, t.RepeatDays, enabled)
+}
+
+func (t SmartTask) Long() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ID: %d\n", t.ID)
+	fmt.Fprintf(&b, "Created at: %s\n", t.CreatedAt)
+	fmt.Fprintf(&b, "Type: %s\n", t.Type)
+	fmt.Fprintf(&b, "Enabled: %s\n", t.Enabled)
+	fmt.Fprintf(&b, "Repeat: %s\n", t.RepeatDays)
+	for i, tr := range t.Triggers {
+		fmt.Fprintf(&b, "Trigger %d: %s\n", i+1, tr)
+	}
+	fmt.Fprintf(&b, "State: %s\n", t.StartAction.State)
+	fmt.Fprintf(&b, "Light count: %d\n", len(t.StartAction.Lights))
+	for i, l := range t.StartAction.Lights {
+		fmt.Fprintf(&b, "Light %d: ID: %d\n", i+1, l.ID)
+		fmt.Fprintf(&b, "Light %d: Dimmer: %s\n", i+1, l.Dimmer)
+		fmt.Fprintf(&b, "Light %d: Transition: %s\n", i+1, time.Duration(l.Transition)*100*time.Millisecond)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (tr TaskTrigger) String() string {
+	s := fmt.Sprintf("%02d:%02d", tr.StartHour, tr.StartMinute)
+	if tr.EndHour != nil && tr.EndMinute != nil {
+		s += fmt.Sprintf("-%02d:%02d", *tr.EndHour, *tr.EndMinute)
+	}
+	return s + " UTC"
+}
+
+type TaskType int
+
+const (
+	TaskNotHome TaskType = 1
+	TaskOnOff   TaskType = 2
+	TaskWakeUp  TaskType = 4
+)
+
+func (tt TaskType) String() string {
+	switch tt {
+	case TaskNotHome:
+		return "not home"
+	case TaskOnOff:
+		return "on/off"
+	case TaskWakeUp:
+		return "wake-up"
+	default:
+		return fmt.Sprintf("unknown task type (%d)", tt)
+	}
+}
+
+// Weekdays is a set of days of the week. A smart task with no repeat days
+// runs once.
+type Weekdays int
+
+const (
+	Monday Weekdays = 1 << iota
+	Tuesday
+	Wednesday
+	Thursday
+	Friday
+	Saturday
+	Sunday
+
+	Everyday Weekdays = Monday | Tuesday | Wednesday | Thursday | Friday | Saturday | Sunday
+)
+
+// WeekdaysOf returns the set containing the given day.
+func WeekdaysOf(d time.Weekday) Weekdays {
+	if d == time.Sunday {
+		return Sunday
+	}
+	return Monday << uint(d-time.Monday)
+}
+
+func (wd Weekdays) String() string {
+	switch wd {
+	case 0:
+		return "once"
+	case Everyday:
+		return "every day"
+	case Monday | Tuesday | Wednesday | Thursday | Friday:
+		return "weekdays"
+	case Saturday | Sunday:
+		return "weekends"
+	}
+	var days []string
+	for d := Monday; d <= Sunday; d <<= 1 {
+		if wd&d != 0 {
+			days = append(days, weekdayNames[d])
+		}
+	}
+	return strings.Join(days, ", ")
+}
+
+var weekdayNames = map[Weekdays]string{
+	Monday:    "mon",
+	Tuesday:   "tue",
+	Wednesday: "wed",
+	Thursday:  "thu",
+	Friday:    "fri",
+	Saturday:  "sat",
+	Sunday:    "sun",
+}
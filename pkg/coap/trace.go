@@ -0,0 +1,81 @@
+package coap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ocf/go-coap/codes"
+)
+
+// Trace describes a single attempt at a request to the gateway, or a
+// notification of an observation that couldn't be decoded.
+type Trace struct {
+	Method   codes.Code
+	Path     string
+	Attempt  int    // 1 for the first attempt, 2 for the first retry, etc.
+	Request  []byte // request payload, if any
+	Code     codes.Code
+	Response []byte // response payload, if any
+	Duration time.Duration
+	Err      error // set if there was no response, or it was invalid
+}
+
+// Tracer is called after every attempt at a request, e.g. to log it. It must
+// be safe for concurrent use.
+type Tracer func(Trace)
+
+// WithTracer sets a tracer. By default, requests aren't traced. The PSK in the
+// response to an auth request is redacted.
+func WithTracer(t Tracer) Option {
+	return func(c *Client) { c.tracer = t }
+}
+
+// String formats the trace as a single line, e.g.
+//
+//	PUT /15001/65537 {"5850":1,"5851":254} -> 68 (Changed) in 12.5ms
+func (t Trace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", t.Method, t.Path)
+	if len(t.Request) > 0 {
+		fmt.Fprintf(&b, " %s", t.Request)
+	}
+	if t.Attempt > 1 {
+		fmt.Fprintf(&b, " (attempt %d)", t.Attempt)
+	}
+	if t.Err != nil {
+		fmt.Fprintf(&b, " -> error: %v", t.Err)
+		if len(t.Response) > 0 {
+			fmt.Fprintf(&b, " %s", t.Response)
+		}
+	} else {
+		fmt.Fprintf(&b, " -> %d (%s)", t.Code, t.Code)
+		if len(t.Response) > 0 {
+			fmt.Fprintf(&b, " %s", t.Response)
+		}
+	}
+	fmt.Fprintf(&b, " in %s", t.Duration.Round(time.Microsecond))
+	return b.String()
+}
+
+// redactPSK replaces the PSK in the response payload of an auth request, so
+// that traces can be shared safely.
+func redactPSK(path string, payload []byte) []byte {
+	if path != fmt.Sprintf("/%d/%d", RootGateway, gatewayAuth) || len(payload) == 0 {
+		return payload
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return []byte("(redacted)")
+	}
+	if _, ok := response["9091"]; ok {
+		response["9091"] = "REDACTED"
+	}
+	redacted, err := json.Marshal(response)
+	if err != nil {
+		return []byte("(redacted)")
+	}
+	return redacted
+}
@@ -0,0 +1,318 @@
+// Package color parses color specifications for color-capable TRÅDFRI bulbs.
+package color
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Model describes how a color should be sent to the gateway.
+type Model int
+
+const (
+	// XY colors are sent as CIE 1931 chromaticity coordinates.
+	XY Model = iota
+
+	// HueSaturation colors are sent as hue and saturation.
+	HueSaturation
+
+	// Preset colors are sent as one of the gateway's predefined hex values,
+	// which also works for white spectrum bulbs.
+	Preset
+)
+
+// Color is a parsed color.
+type Color struct {
+	Model      Model
+	X, Y       float64 // CIE 1931 chromaticity, 0..1
+	Hue        float64 // degrees, 0..360
+	Saturation float64 // 0..1
+	Brightness float64 // 0..1, only for HueSaturation colors
+	Hex        string  // rrggbb, without a leading #
+}
+
+// Presets are the named colors predefined by the gateway, as RGB hex.
+var Presets = map[string]string{
+	"blue":             "4a418a",
+	"candlelight":      "ebb63e",
+	"cold_sky":         "dcf0f8",
+	"cool_daylight":    "eaf6fb",
+	"cool_white":       "f5faf6",
+	"dark_peach":       "da5d41",
+	"light_blue":       "6c83ba",
+	"light_pink":       "e8bedd",
+	"light_purple":     "c984bb",
+	"lime":             "a9d62b",
+	"peach":            "e57345",
+	"pink":             "e491af",
+	"saturated_pink":   "d9337c",
+	"saturated_purple": "8f2686",
+	"saturated_red":    "dc4b31",
+	"sunrise":          "f2eccf",
+	"warm_amber":       "e78834",
+	"warm_glow":        "efd275",
+	"warm_white":       "f1e0b5",
+	"yellow":           "d6e44b",
+}
+
+// Parse a color specification, which may be
+//
+//	#rrggbb or rrggbb        RGB hex
+//	rgb(r, g, b) or r,g,b    RGB, each 0..255
+//	hsb(h, s, b)             hue 0..360, saturation and brightness 0..100
+//	xy(x, y)                 CIE 1931 chromaticity, each 0..1
+//	a CSS color name         e.g. "rebeccapurple"
+//	a gateway preset name    e.g. "warm_white", see Presets
+//
+// CSS names take precedence over gateway presets with the same name. Hex
+// values that match a gateway preset are returned as presets.
+func Parse(s string) (Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")"):
+		f, err := parseFloats(s[4:len(s)-1], 3)
+		if err != nil {
+			return Color{}, fmt.Errorf("invalid RGB color %q: %w", s, err)
+		}
+		return fromRGB(f[0], f[1], f[2])
+
+	case strings.HasPrefix(s, "hsb(") && strings.HasSuffix(s, ")"):
+		f, err := parseFloats(s[4:len(s)-1], 3)
+		if err != nil {
+			return Color{}, fmt.Errorf("invalid HSB color %q: %w", s, err)
+		}
+		return fromHSB(f[0], f[1], f[2])
+
+	case strings.HasPrefix(s, "xy(") && strings.HasSuffix(s, ")"):
+		f, err := parseFloats(s[3:len(s)-1], 2)
+		if err != nil {
+			return Color{}, fmt.Errorf("invalid xy color %q: %w", s, err)
+		}
+		return fromXY(f[0], f[1])
+
+	case strings.Count(s, ",") == 2:
+		f, err := parseFloats(s, 3)
+		if err != nil {
+			return Color{}, fmt.Errorf("invalid RGB color %q: %w", s, err)
+		}
+		return fromRGB(f[0], f[1], f[2])
+	}
+
+	name := strings.NewReplacer(" ", "", "-", "", "_", "").Replace(s)
+	if hex, ok := cssNames[name]; ok {
+		return fromHex(hex)
+	}
+	for preset, hex := range Presets {
+		if strings.Replace(preset, "_", "", -1) == name {
+			return fromHex(hex)
+		}
+	}
+
+	return fromHex(strings.TrimPrefix(s, "#"))
+}
+
+func parseFloats(s string, n int) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("want %d components, have %d", n, len(fields))
+	}
+	f := make([]float64, n)
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+		f[i] = v
+	}
+	return f, nil
+}
+
+func fromHex(hex string) (Color, error) {
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return Color{}, fmt.Errorf("invalid color %q", hex)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid color %q", hex)
+	}
+
+	c, err := fromRGB(float64(v>>16&0xff), float64(v>>8&0xff), float64(v&0xff))
+	if err != nil {
+		return Color{}, err
+	}
+
+	for _, preset := range Presets {
+		if preset == hex {
+			c.Model = Preset
+		}
+	}
+
+	return c, nil
+}
+
+func fromRGB(r, g, b float64) (Color, error) {
+	for _, v := range []float64{r, g, b} {
+		if v < 0 || v > 255 {
+			return Color{}, fmt.Errorf("RGB component %v out of range 0..255", v)
+		}
+	}
+
+	x, y := rgbToXY(r/255, g/255, b/255)
+	h, s, _ := rgbToHSB(r/255, g/255, b/255)
+	return Color{
+		Model:      XY,
+		X:          x,
+		Y:          y,
+		Hue:        h,
+		Saturation: s,
+		Hex:        fmt.Sprintf("%02x%02x%02x", int(r), int(g), int(b)),
+	}, nil
+}
+
+func fromHSB(h, s, b float64) (Color, error) {
+	switch {
+	case h < 0 || h > 360:
+		return Color{}, fmt.Errorf("hue %v out of range 0..360", h)
+	case s < 0 || s > 100:
+		return Color{}, fmt.Errorf("saturation %v out of range 0..100", s)
+	case b < 0 || b > 100:
+		return Color{}, fmt.Errorf("brightness %v out of range 0..100", b)
+	}
+
+	r, g, bl := hsbToRGB(h, s/100, b/100)
+	c, err := fromRGB(math.Round(r*255), math.Round(g*255), math.Round(bl*255))
+	if err != nil {
+		return Color{}, err
+	}
+	c.Model = HueSaturation
+	c.Hue, c.Saturation, c.Brightness = h, s/100, b/100
+	return c, nil
+}
+
+func fromXY(x, y float64) (Color, error) {
+	if x < 0 || x > 1 || y <= 0 || y > 1 {
+		return Color{}, fmt.Errorf("xy coordinates (%v, %v) out of range", x, y)
+	}
+
+	r, g, b := xyToRGB(x, y)
+	h, s, _ := rgbToHSB(r, g, b)
+	return Color{
+		Model:      XY,
+		X:          x,
+		Y:          y,
+		Hue:        h,
+		Saturation: s,
+		Hex:        fmt.Sprintf("%02x%02x%02x", int(math.Round(r*255)), int(math.Round(g*255)), int(math.Round(b*255))),
+	}, nil
+}
+
+//
+//
+//
+
+// D65 is the chromaticity of the sRGB white point.
+var d65 = [2]float64{0.3127, 0.3290}
+
+// rgbToXY converts sRGB, each 0..1, to CIE 1931 xy.
+func rgbToXY(r, g, b float64) (x, y float64) {
+	r, g, b = linearize(r), linearize(g), linearize(b)
+	var (
+		X = 0.4124*r + 0.3576*g + 0.1805*b
+		Y = 0.2126*r + 0.7152*g + 0.0722*b
+		Z = 0.0193*r + 0.1192*g + 0.9505*b
+	)
+	if sum := X + Y + Z; sum > 0 {
+		return X / sum, Y / sum
+	}
+	return d65[0], d65[1]
+}
+
+// xyToRGB converts CIE 1931 xy to sRGB at full brightness, each 0..1.
+func xyToRGB(x, y float64) (r, g, b float64) {
+	var (
+		Y = 1.0
+		X = (Y / y) * x
+		Z = (Y / y) * (1 - x - y)
+	)
+	r = 3.2406*X - 1.5372*Y - 0.4986*Z
+	g = -0.9689*X + 1.8758*Y + 0.0415*Z
+	b = 0.0557*X - 0.2040*Y + 1.0570*Z
+
+	r, g, b = math.Max(r, 0), math.Max(g, 0), math.Max(b, 0)
+	if max := math.Max(r, math.Max(g, b)); max > 1 {
+		r, g, b = r/max, g/max, b/max
+	}
+	return gamma(r), gamma(g), gamma(b)
+}
+
+func linearize(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func gamma(c float64) float64 {
+	if c <= 0.0031308 {
+		return 12.92 * c
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// rgbToHSB converts RGB, each 0..1, to hue 0..360, saturation and brightness
+// 0..1.
+func rgbToHSB(r, g, b float64) (h, s, v float64) {
+	var (
+		max   = math.Max(r, math.Max(g, b))
+		min   = math.Min(r, math.Min(g, b))
+		delta = max - min
+	)
+	switch {
+	case delta == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	if max > 0 {
+		s = delta / max
+	}
+	return h, s, max
+}
+
+// hsbToRGB converts hue 0..360, saturation and brightness 0..1 to RGB, each
+// 0..1.
+func hsbToRGB(h, s, v float64) (r, g, b float64) {
+	var (
+		c = v * s
+		x = c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+		m = v - c
+	)
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
@@ -0,0 +1,61 @@
+package color
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  Color
+	}{
+		{"#ff0000", Color{Model: XY, X: 0.6401, Y: 0.3300, Hue: 0, Saturation: 1, Hex: "ff0000"}},
+		{"f00", Color{Model: XY, X: 0.6401, Y: 0.3300, Hue: 0, Saturation: 1, Hex: "ff0000"}},
+		{"rgb(0, 255, 0)", Color{Model: XY, X: 0.3000, Y: 0.6000, Hue: 120, Saturation: 1, Hex: "00ff00"}},
+		{"0,0,255", Color{Model: XY, X: 0.1500, Y: 0.0600, Hue: 240, Saturation: 1, Hex: "0000ff"}},
+		{"hsb(120, 100, 100)", Color{Model: HueSaturation, X: 0.3000, Y: 0.6000, Hue: 120, Saturation: 1, Brightness: 1, Hex: "00ff00"}},
+		{"hsb(0, 50, 100)", Color{Model: HueSaturation, X: 0.4551, Y: 0.3294, Hue: 0, Saturation: 0.5, Brightness: 1, Hex: "ff8080"}},
+		{"hsb(120, 100, 50)", Color{Model: HueSaturation, X: 0.3000, Y: 0.6000, Hue: 120, Saturation: 1, Brightness: 0.5, Hex: "008000"}},
+		{"xy(0.3127, 0.3290)", Color{Model: XY, X: 0.3127, Y: 0.3290, Hue: 0, Saturation: 0, Hex: "ffffff"}},
+		{"RebeccaPurple", Color{Model: XY, X: 0.2442, Y: 0.1474, Hue: 270, Saturation: 0.6667, Hex: "663399"}},
+		{"warm white", Color{Model: Preset, X: 0.3544, Y: 0.3746, Hue: 43, Saturation: 0.249, Hex: "f1e0b5"}},
+		{"#F1E0B5", Color{Model: Preset, X: 0.3544, Y: 0.3746, Hue: 43, Saturation: 0.249, Hex: "f1e0b5"}},
+	} {
+		have, err := Parse(tc.input)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if tc.want.Model != have.Model || tc.want.Hex != have.Hex ||
+			!near(tc.want.X, have.X) || !near(tc.want.Y, have.Y) ||
+			!near(tc.want.Saturation, have.Saturation) || !near(tc.want.Brightness, have.Brightness) ||
+			(have.Saturation > 0.001 && math.Abs(tc.want.Hue-have.Hue) > 0.1) {
+			t.Errorf("%q: want %+v, have %+v", tc.input, tc.want, have)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"nope",
+		"#12345",
+		"#gggggg",
+		"rgb(256, 0, 0)",
+		"rgb(1, 2)",
+		"-1,0,0",
+		"hsb(400, 0, 0)",
+		"hsb(0, 101, 0)",
+		"xy(0.3, 0)",
+		"xy(1.5, 0.5)",
+	} {
+		if c, err := Parse(input); err == nil {
+			t.Errorf("%q: want error, have %+v", input, c)
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
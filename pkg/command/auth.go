@@ -2,21 +2,20 @@ package command
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/peterbourgon/ff/v2/ffcli"
 	"github.com/peterbourgon/lightctl/pkg/coap"
 	"github.com/peterbourgon/lightctl/pkg/config"
 )
 
-func Auth(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func Auth(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl auth", flag.ExitOnError)
 	var (
 		username = fs.String("username", "", "username of your choice")
@@ -25,37 +24,100 @@ func Auth(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
 
 	return &ffcli.Command{
 		Name:       "auth",
-		ShortUsage: "lightctl auth [flags]",
+		ShortUsage: "lightctl [-profile name] [-gateway address] auth [flags]",
 		ShortHelp:  "Authenticate with the TRÅDFRI gateway",
+		LongHelp:   "Authenticate with the TRÅDFRI gateway, and save the gateway address and credentials as a profile. If neither -gateway nor the profile has an address, the gateway is discovered on the local network. The profile is named by the root -profile flag, and defaults to the default profile, unless that's for a different gateway than -gateway, in which case it's named after the gateway host. The first profile becomes the default.",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, "Client_identity", *code)
+			c, err := config.Load()
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("error loading config: %w", err)
+			}
+
+			name, err := authProfileName(c, root.Profile, root.Gateway)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			psk, err := client.Auth(*username)
+			p := c.Profiles[name]
+			if root.Gateway == "" && p.Gateway == "" {
+				g, err := discoverGateway(ctx)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(stderr, "discovered gateway %s at %s\n", g.Name, g.Address())
+				p.Gateway = g.Address()
+			}
+
+			u, err := root.gatewayURL(p)
 			if err != nil {
-				return fmt.Errorf("error performing auth: %w", err)
+				return err
 			}
 
-			buf, err := json.Marshal(config.Config{
-				Username: *username,
-				PSK:      psk,
-			})
+			client, err := coap.NewClient(ctx, u.Scheme, u.Host, "Client_identity", *code, root.clientOptions()...)
 			if err != nil {
-				return fmt.Errorf("error marshaling config: %w", err)
+				return fmt.Errorf("error dialing gateway: %w", err)
 			}
 
-			if err := os.MkdirAll(filepath.Dir(config.FilePath), 0700); err != nil {
-				return fmt.Errorf("error creating config directory: %w", err)
+			psk, err := client.Auth(ctx, *username)
+			if err != nil {
+				return fmt.Errorf("error performing auth: %w", err)
 			}
 
-			if err := ioutil.WriteFile(config.FilePath, buf, 0600); err != nil {
-				return fmt.Errorf("error writing config file: %w", err)
+			c.Set(name, config.Profile{
+				Gateway:  u.String(),
+				Username: *username,
+				PSK:      psk,
+			})
+			if err := config.Save(c); err != nil {
+				return err
 			}
 
+			fmt.Fprintf(stderr, "saved profile %q for %s\n", name, u)
 			return nil
 		},
 	}
 }
+
+// authProfileName returns the name of the profile that auth saves. That's the
+// given name, if any. Otherwise, it's the default profile, unless that's for
+// a different gateway, which mustn't be clobbered: then it's the host of the
+// gateway, e.g. "office" for udp://office:5684.
+func authProfileName(c config.Config, name, gateway string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+
+	name = c.Default
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	current, ok := c.Profiles[name]
+	if !ok || gateway == "" || current.Gateway == "" || sameGateway(current.Gateway, gateway) {
+		return name, nil
+	}
+
+	u, err := url.Parse(gateway)
+	if err != nil {
+		return "", fmt.Errorf("error parsing gateway: %w", err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("profile %q is for gateway %s, choose a profile name with -profile", name, current.Gateway)
+	}
+	if existing, ok := c.Profiles[host]; ok && existing.Gateway != "" && !sameGateway(existing.Gateway, gateway) {
+		return "", fmt.Errorf("profile %q is for gateway %s, choose a profile name with -profile", host, existing.Gateway)
+	}
+	return host, nil
+}
+
+// sameGateway reports whether two gateway addresses are the same, ignoring
+// differences in how they're written, e.g. the case of the host.
+func sameGateway(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
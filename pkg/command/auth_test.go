@@ -0,0 +1,46 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/peterbourgon/lightctl/pkg/config"
+)
+
+func TestAuthProfileName(t *testing.T) {
+	c := config.Config{
+		Default: "home",
+		Profiles: map[string]config.Profile{
+			"home":   {Gateway: "udp://10.0.1.11:5684"},
+			"office": {Gateway: "udp://10.0.2.11:5684"},
+			"legacy": {},
+		},
+	}
+
+	for _, tc := range []struct {
+		config  config.Config
+		profile string
+		gateway string
+		want    string // empty for an error
+	}{
+		{config.Config{}, "", "", "default"},
+		{config.Config{}, "", "udp://office:5684", "default"},
+		{c, "", "", "home"},
+		{c, "", "udp://10.0.1.11:5684", "home"},
+		{c, "", "UDP://10.0.1.11:5684", "home"},
+		{c, "", "udp://10.0.3.11:5684", "10.0.3.11"},
+		{c, "", "udp://office:5684", ""}, // office is for another gateway
+		{c, "office", "udp://office:5684", "office"},
+		{c, "home", "udp://10.0.3.11:5684", "home"},
+		{config.Config{Default: "legacy", Profiles: c.Profiles}, "", "udp://10.0.3.11:5684", "legacy"},
+	} {
+		have, err := authProfileName(tc.config, tc.profile, tc.gateway)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("-profile %q -gateway %q: want error, have %q", tc.profile, tc.gateway, have)
+		case tc.want != "" && err != nil:
+			t.Errorf("-profile %q -gateway %q: %v", tc.profile, tc.gateway, err)
+		case tc.want != have:
+			t.Errorf("-profile %q -gateway %q: want %q, have %q", tc.profile, tc.gateway, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/circadian"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func Circadian(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "circadian",
+		ShortUsage: "lightctl circadian <subcommand> ...",
+		ShortHelp:  "Adjust lights to follow the course of the sun",
+		Subcommands: []*ffcli.Command{
+			CircadianRun(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+func CircadianRun(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl circadian run", flag.ExitOnError)
+	var (
+		configFile = fs.String("config", "", "config file (YAML) with location and groups")
+		interval   = fs.Duration("interval", time.Minute, "how often to adjust the lights")
+	)
+
+	return &ffcli.Command{
+		Name:       "run",
+		ShortUsage: "lightctl circadian run [flags]",
+		ShortHelp:  "Continuously adjust level and white of groups to the height of the sun",
+		LongHelp: "Continuously adjust level and white of groups to the height of the sun, as printed by the sun command. " +
+			"Each group moves between its configured minimum at night, and its maximum at solar noon, fading over each interval. " +
+			"Groups that are off are left alone, and adjusted as soon as they're turned on.",
+		FlagSet: fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *configFile == "" {
+				return fmt.Errorf("config file is required")
+			}
+			if *interval < time.Second {
+				return fmt.Errorf("interval must be at least 1s")
+			}
+
+			f, err := os.Open(*configFile)
+			if err != nil {
+				return fmt.Errorf("error opening config file: %w", err)
+			}
+			config, err := circadian.ReadConfig(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("error reading config file: %w", err)
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			ids := make([]int, len(config.Groups))
+			for i, g := range config.Groups {
+				if ids[i], err = resolveGroup(ctx, client, 0, g.Name); err != nil {
+					return err
+				}
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var (
+				wg   sync.WaitGroup
+				mtx  sync.Mutex // serializes output
+				errs = make(chan error, len(ids))
+			)
+			logf := func(format string, args ...interface{}) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				fmt.Fprintf(stdout, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+			}
+			for i, id := range ids {
+				d := circadianGroup{
+					client:   client,
+					id:       id,
+					group:    config.Groups[i],
+					config:   config,
+					interval: *interval,
+					logf:     logf,
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := d.run(ctx); err != nil {
+						errs <- err
+						cancel()
+					}
+				}()
+			}
+
+			fmt.Fprintf(stderr, "adjusting %d group%s every %s\n", len(ids), plural(len(ids)), *interval)
+
+			wg.Wait()
+			select {
+			case err := <-errs:
+				return err
+			default:
+				return nil
+			}
+		},
+	}
+}
+
+// circadianGroup adjusts a single group.
+type circadianGroup struct {
+	client   *coap.Client
+	id       int
+	group    circadian.Group
+	config   circadian.Config
+	interval time.Duration
+	logf     func(format string, args ...interface{})
+}
+
+// run observes the group, to know whether it's on, and adjusts it every
+// interval, until the context is canceled.
+func (d circadianGroup) run(ctx context.Context) error {
+	updates, err := d.client.ObserveGroup(ctx, d.id)
+	if err != nil {
+		return fmt.Errorf("error observing group %d: %w", d.id, err)
+	}
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	var (
+		name string
+		on   bool
+		last *circadian.Setting // nil if unknown
+	)
+	apply := func(transition time.Duration) {
+		height := circadian.SunHeight(time.Now(), d.config.Latitude, d.config.Longitude)
+		s := d.group.At(height)
+		if last != nil && *last == s {
+			return
+		}
+
+		var (
+			dimmer = coap.Percent255(dimmerFrom(s.Level))
+			mireds = miredsFrom(s.White)
+			tenths = int(transition.Seconds() * 10)
+		)
+		err := d.client.SetLightControl(ctx, coap.RootGroups, d.id, coap.LightControlInput{
+			Dimmer:      &dimmer,
+			LightMireds: &mireds,
+			Transition:  &tenths,
+		})
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return
+		case err != nil:
+			d.logf("group %d %s: error: %v", d.id, name, err)
+			last = nil // try again next interval
+		default:
+			d.logf("group %d %s: level %d white %d", d.id, name, s.Level, s.White)
+			last = &s
+		}
+	}
+
+	for {
+		select {
+		case g, ok := <-updates:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("observation of group %d ended", d.id)
+			}
+			name = g.Name
+			wasOn := on
+			on = g.State != 0
+			if on && !wasOn {
+				last = nil // the gateway restores the level it had when turned off
+				apply(0)
+			}
+
+		case <-ticker.C:
+			if on {
+				apply(d.interval)
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
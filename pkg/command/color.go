@@ -0,0 +1,53 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/color"
+)
+
+const colorHelp = "#rrggbb, CSS or preset name, rgb(r,g,b), hsb(h,s,b), xy(x,y)"
+
+// setLightColor sends the color to the device or group in whichever form
+// suits it: presets as hex, HSB as hue, saturation, and dimmer, everything else
+// as xy.
+func setLightColor(ctx context.Context, client *coap.Client, root, id int, c color.Color, transition time.Duration) error {
+	switch c.Model {
+	case color.Preset:
+		return client.SetLightControlColorHex(ctx, root, id, c.Hex, transition)
+	case color.HueSaturation:
+		var (
+			hue, saturation = gatewayHueSaturation(c)
+			dimmer          = gatewayDimmer(c)
+			tenths          = int(transition.Seconds() * 10)
+		)
+		return client.SetLightControl(ctx, root, id, coap.LightControlInput{
+			Hue:        &hue,
+			Saturation: &saturation,
+			Dimmer:     &dimmer,
+			Transition: &tenths,
+		})
+	case color.XY:
+		x, y := gatewayXY(c)
+		return client.SetLightControlColorXY(ctx, root, id, x, y, transition)
+	default:
+		return fmt.Errorf("unsupported color model %d", c.Model)
+	}
+}
+
+func gatewayXY(c color.Color) (x, y int) {
+	return int(c.X * 65535), int(c.Y * 65535)
+}
+
+func gatewayHueSaturation(c color.Color) (hue, saturation int) {
+	return int(c.Hue / 360 * 65279), int(c.Saturation * 65279)
+}
+
+// gatewayDimmer returns the brightness of an HSB color as a dimmer value.
+func gatewayDimmer(c color.Color) coap.Percent255 {
+	return coap.Percent255(dimmerFrom(int(math.Round(c.Brightness * 100))))
+}
@@ -0,0 +1,51 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/config"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+// testGateway serves the fake gateway, and saves a default profile for it to a
+// temporary config file. The returned root config dials it. The returned
+// function stops the gateway, and restores the config file path.
+func testGateway(t *testing.T, g *fakegateway.Gateway) (*RootConfig, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "lightctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := config.FilePath
+	config.FilePath = filepath.Join(dir, "lightctl.conf")
+
+	g.AddIdentity("test", "secret")
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var c config.Config
+	c.Set(config.DefaultProfile, config.Profile{Gateway: g.URL(), Username: "test", PSK: "secret"})
+	if err := config.Save(c); err != nil {
+		t.Fatal(err)
+	}
+
+	return &RootConfig{Output: "text"}, func() {
+		g.Close()
+		config.FilePath = path
+		os.RemoveAll(dir)
+	}
+}
+
+func testLight(name string) coap.Device {
+	var d coap.Device
+	d.Name = name
+	d.Reachable = 1
+	d.LightControl = []coap.LightControl{{State: 1, Dimmer: 254, LightMireds: 370}}
+	return d
+}
@@ -2,54 +2,58 @@ package command
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"net/url"
-	"os"
+	"time"
 
 	"github.com/peterbourgon/ff/v2/ffcli"
 	"github.com/peterbourgon/lightctl/pkg/coap"
-	"github.com/peterbourgon/lightctl/pkg/config"
+	"github.com/peterbourgon/lightctl/pkg/color"
 )
 
-func Device(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func Device(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "device",
 		ShortUsage: "lightctl device <subcommand> ...",
 		ShortHelp:  "Interact with devices",
 		Subcommands: []*ffcli.Command{
-			DeviceList(gateway, stdout, stderr),
-			DeviceGet(gateway, stdout, stderr),
-			DeviceSet(gateway, stdout, stderr),
+			DeviceList(root, stdout, stderr),
+			DeviceGet(root, stdout, stderr),
+			DeviceSet(root, stdout, stderr),
+			DeviceRename(root, stdout, stderr),
+			DeviceRemove(root, stdout, stderr),
+			DeviceIdentify(root, stdout, stderr),
 		},
 		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func DeviceList(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "list",
 		ShortUsage: "lightctl device list",
 		ShortHelp:  "List known devices",
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
-			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+			// Write whatever devices could be fetched, and report the rest.
+			devices, err := client.ListDevices(ctx)
+			var partial *coap.PartialError
+			if err != nil && !errors.As(err, &partial) {
+				return fmt.Errorf("error listing devices: %w", err)
 			}
 
-			devices, err := client.ListDevices()
-			if err != nil {
-				return fmt.Errorf("error listing devices: %w", err)
+			if err := writeDevices(stdout, root.Output, devices); err != nil {
+				return err
 			}
 
-			for _, d := range devices {
-				fmt.Fprintf(os.Stdout, "%s\n", d.Short())
+			if partial != nil {
+				return fmt.Errorf("error listing devices: %w", partial)
 			}
 
 			return nil
@@ -57,74 +61,106 @@ func DeviceList(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
 	}
 }
 
-func DeviceGet(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceGet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl device get", flag.ExitOnError)
 	var (
-		id = fs.Int("id", 0, "device ID")
+		id   = fs.Int("id", 0, "device ID")
+		name = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
 	)
 
 	return &ffcli.Command{
 		Name:       "get",
-		ShortUsage: "lightctl device get",
+		ShortUsage: "lightctl device get [flags]",
 		ShortHelp:  "Get detailed information about a device",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			if len(args) < 1 {
-				return flag.ErrHelp
-			}
-
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			d, err := client.GetDevice(*id)
+			d, err := client.GetDevice(ctx, deviceID)
 			if err != nil {
-				return fmt.Errorf("error getting device %d: %w", *id, err)
+				return fmt.Errorf("error getting device %d: %w", deviceID, err)
 			}
 
-			fmt.Fprintf(stdout, "%+v\n", d.Long())
-
-			return nil
+			return writeDevice(stdout, root.Output, d)
 		},
 	}
 }
 
-func DeviceSet(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceSet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "set",
 		ShortUsage: "lightctl device set <subcommand>",
 		ShortHelp:  "Set properties of a device",
 		Subcommands: []*ffcli.Command{
-			DeviceSetLight(gateway, stdout, stderr),
+			DeviceSetLight(root, stdout, stderr),
 		},
 		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func DeviceSetLight(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceSetLight(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl device set light", flag.ExitOnError)
+	var (
+		id    = fs.Int("id", 0, "device ID")
+		name  = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
+		light lightFlags
+	)
+	fs.Var(&light.state, "state", "on, off")
+	fs.Var(&light.level, "level", "0..100")
+	fs.Var(&light.white, "white", "0..100 (0=red, 100=white)")
+	fs.Var(&light.color, "color", colorHelp)
+	fs.DurationVar(&light.transition, "transition", 0, "transition time")
+
 	return &ffcli.Command{
 		Name:       "light",
-		ShortUsage: "lightctl device set light <subcommand>",
+		ShortUsage: "lightctl device set light [flags] | <subcommand>",
 		ShortHelp:  "Set light control properties of a device",
+		LongHelp:   "Set any combination of light control properties of a device in a single request.",
+		FlagSet:    fs,
 		Subcommands: []*ffcli.Command{
-			DeviceSetLightState(gateway, stdout, stderr),
-			DeviceSetLightLevel(gateway, stdout, stderr),
-			DeviceSetLightWhite(gateway, stdout, stderr),
+			DeviceSetLightState(root, stdout, stderr),
+			DeviceSetLightLevel(root, stdout, stderr),
+			DeviceSetLightWhite(root, stdout, stderr),
+			DeviceSetLightColor(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			if light.empty() {
+				return flag.ErrHelp
+			}
+
+			input, err := light.input()
+			if err != nil {
+				return err
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.SetLightControl(ctx, coap.RootDevices, deviceID, input)
 		},
-		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func DeviceSetLightState(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceSetLightState(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl device set light state", flag.ExitOnError)
 	var (
 		id    = fs.Int("id", 0, "device ID")
+		name  = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
 		state = fs.String("state", "", "on, off")
 	)
 
@@ -134,25 +170,26 @@ func DeviceSetLightState(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comm
 		ShortHelp:  "Set light control state of a device",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			return client.SetLightControlState(coap.RootDevices, *id, *state == "on")
+			return client.SetLightControlState(ctx, coap.RootDevices, deviceID, *state == "on")
 		},
 	}
 }
 
-func DeviceSetLightLevel(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceSetLightLevel(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl device set light level", flag.ExitOnError)
 	var (
 		id         = fs.Int("id", 0, "device ID")
+		name       = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
 		level      = fs.Int("level", 0, "0..100")
 		transition = fs.Duration("transition", 0, "transition time")
 	)
@@ -163,33 +200,26 @@ func DeviceSetLightLevel(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comm
 		ShortHelp:  "Set light control level of a device",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
-			}
-
-			if *level < 0 {
-				*level = 0
-			}
-			if *level > 100 {
-				*level = 100
+				return err
 			}
 
-			dimmer := int((float64(*level) / 100) * 255.0)
-			return client.SetLightControlDimmer(coap.RootDevices, *id, dimmer, *transition)
+			return client.SetLightControlDimmer(ctx, coap.RootDevices, deviceID, dimmerFrom(*level), *transition)
 		},
 	}
 }
 
-func DeviceSetLightWhite(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func DeviceSetLightWhite(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl device set light white", flag.ExitOnError)
 	var (
 		id         = fs.Int("id", 0, "device ID")
+		name       = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
 		white      = fs.Int("white", 0, "0..100 (0=red, 100=white)")
 		transition = fs.Duration("transition", 0, "transition time")
 	)
@@ -200,26 +230,246 @@ func DeviceSetLightWhite(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comm
 		ShortHelp:  "Set light control miwhites (white spectrum color) of a device",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.SetLightControlMireds(ctx, coap.RootDevices, deviceID, miredsFrom(*white), *transition)
+		},
+	}
+}
+
+func DeviceSetLightColor(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl device set light color", flag.ExitOnError)
+	var (
+		id         = fs.Int("id", 0, "device ID")
+		name       = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
+		colorStr   = fs.String("color", "", colorHelp)
+		transition = fs.Duration("transition", 0, "transition time")
+	)
+
+	return &ffcli.Command{
+		Name:       "color",
+		ShortUsage: "lightctl device set light color [flags]",
+		ShortHelp:  "Set light control color of a device",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			col, err := color.Parse(*colorStr)
+			if err != nil {
+				return err
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return setLightColor(ctx, client, coap.RootDevices, deviceID, col, *transition)
+		},
+	}
+}
+
+func DeviceRename(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl device rename", flag.ExitOnError)
+	var (
+		id   = fs.Int("id", 0, "device ID")
+		name = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
+		to   = fs.String("to", "", "new name of the device")
+	)
+
+	return &ffcli.Command{
+		Name:       "rename",
+		ShortUsage: "lightctl device rename [flags]",
+		ShortHelp:  "Rename a device",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *to == "" {
+				return fmt.Errorf("new device name is required")
+			}
+
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
+			}
+
+			return client.RenameDevice(ctx, deviceID, *to)
+		},
+	}
+}
+
+func DeviceRemove(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl device remove", flag.ExitOnError)
+	var (
+		id   = fs.Int("id", 0, "device ID")
+		name = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
+		yes  = fs.Bool("yes", false, "confirm the removal")
+	)
+
+	return &ffcli.Command{
+		Name:       "remove",
+		ShortUsage: "lightctl device remove -yes [flags]",
+		ShortHelp:  "Unpair a device from the gateway",
+		LongHelp:   "Unpair a device from the gateway. It must be paired again with the app before it can be used.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if !*yes {
+				return fmt.Errorf("refusing to remove the device without -yes")
 			}
 
-			if *white < 0 {
-				*white = 0
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
 			}
-			if *white > 100 {
-				*white = 100
+
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
+			if err != nil {
+				return err
 			}
 
-			red := 100 - *white
-			mireds := 250 + int((float64(red)/100)*(454-250))
-			return client.SetLightControlMireds(coap.RootDevices, *id, mireds, *transition)
+			return client.RemoveDevice(ctx, deviceID)
 		},
 	}
 }
+
+func DeviceIdentify(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl device identify", flag.ExitOnError)
+	var (
+		id       = fs.Int("id", 0, "device ID")
+		name     = fs.String("name", "", "device name or glob pattern, e.g. \"Kitchen*\"")
+		count    = fs.Int("count", 5, "number of blinks")
+		interval = fs.Duration("interval", 500*time.Millisecond, "time between toggles")
+	)
+
+	return &ffcli.Command{
+		Name:       "identify",
+		ShortUsage: "lightctl device identify [flags]",
+		ShortHelp:  "Blink a light, so it can be found physically",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			deviceID, err := resolveDevice(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.IdentifyDevice(ctx, deviceID, *count, *interval)
+		},
+	}
+}
+
+//
+//
+//
+
+func writeDevice(w io.Writer, format string, d coap.Device) error {
+	return writeOne(w, format, deviceOutputFrom(d), d.Long())
+}
+
+func writeDevices(w io.Writer, format string, devices []coap.Device) error {
+	var (
+		vs    = make([]interface{}, len(devices))
+		texts = make([]string, len(devices))
+	)
+	for i, d := range devices {
+		vs[i], texts[i] = deviceOutputFrom(d), d.Short()
+	}
+	return writeMany(w, format, vs, texts)
+}
+
+type deviceOutput struct {
+	ID           int                  `json:"id" yaml:"id"`
+	Name         string               `json:"name" yaml:"name"`
+	CreatedAt    time.Time            `json:"created_at" yaml:"created_at"`
+	Manufacturer string               `json:"manufacturer" yaml:"manufacturer"`
+	Model        string               `json:"model" yaml:"model"`
+	Serial       string               `json:"serial" yaml:"serial"`
+	Firmware     string               `json:"firmware" yaml:"firmware"`
+	PowerSource  string               `json:"power_source" yaml:"power_source"`
+	BatteryLevel int                  `json:"battery_level" yaml:"battery_level"`
+	LastSeen     time.Time            `json:"last_seen" yaml:"last_seen"`
+	Reachable    bool                 `json:"reachable" yaml:"reachable"`
+	LightControl []lightControlOutput `json:"light_control" yaml:"light_control"`
+}
+
+// The csv format has a column for each property of the device, and of its
+// first light control, if any.
+func (o deviceOutput) csvHeader() []string {
+	return []string{
+		"id", "name", "created_at", "manufacturer", "model", "serial", "firmware", "power_source", "battery_level", "last_seen", "reachable",
+		"on", "level", "color_hex", "hue", "saturation", "color_x", "color_y", "mireds",
+	}
+}
+
+func (o deviceOutput) csvRecord() []string {
+	record := []string{
+		fmt.Sprint(o.ID), o.Name, o.CreatedAt.Format(time.RFC3339), o.Manufacturer, o.Model, o.Serial, o.Firmware, o.PowerSource, fmt.Sprint(o.BatteryLevel), o.LastSeen.Format(time.RFC3339), fmt.Sprint(o.Reachable),
+	}
+	if len(o.LightControl) == 0 {
+		return append(record, "", "", "", "", "", "", "", "")
+	}
+	lc := o.LightControl[0]
+	return append(record,
+		fmt.Sprint(lc.On), fmt.Sprint(lc.Level), lc.ColorHex, fmt.Sprint(lc.Hue), fmt.Sprint(lc.Saturation), fmt.Sprint(lc.ColorX), fmt.Sprint(lc.ColorY), fmt.Sprint(lc.Mireds),
+	)
+}
+
+type lightControlOutput struct {
+	On         bool   `json:"on" yaml:"on"`
+	Level      int    `json:"level" yaml:"level"`
+	ColorHex   string `json:"color_hex" yaml:"color_hex"`
+	Hue        int    `json:"hue" yaml:"hue"`
+	Saturation int    `json:"saturation" yaml:"saturation"`
+	ColorX     int    `json:"color_x" yaml:"color_x"`
+	ColorY     int    `json:"color_y" yaml:"color_y"`
+	Mireds     int    `json:"mireds" yaml:"mireds"`
+}
+
+func deviceOutputFrom(d coap.Device) deviceOutput {
+	lcs := make([]lightControlOutput, len(d.LightControl))
+	for i, lc := range d.LightControl {
+		lcs[i] = lightControlOutput{
+			On:         lc.State != 0,
+			Level:      lc.Dimmer.Percent(),
+			ColorHex:   lc.LightColorHex,
+			Hue:        lc.Hue,
+			Saturation: lc.Saturation,
+			ColorX:     lc.LightColorX,
+			ColorY:     lc.LightColorY,
+			Mireds:     lc.LightMireds,
+		}
+	}
+	return deviceOutput{
+		ID:           d.ID,
+		Name:         d.Name,
+		CreatedAt:    timeFrom(d.CreatedAt),
+		Manufacturer: d.DeviceInfo.Manufacturer,
+		Model:        d.DeviceInfo.Model,
+		Serial:       d.DeviceInfo.Serial,
+		Firmware:     d.DeviceInfo.Firmware,
+		PowerSource:  d.DeviceInfo.PowerSource.String(),
+		BatteryLevel: int(d.DeviceInfo.BatteryLevel),
+		LastSeen:     timeFrom(d.LastSeen),
+		Reachable:    d.Reachable != 0,
+		LightControl: lcs,
+	}
+}
@@ -0,0 +1,89 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/discovery"
+)
+
+func Discover(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl discover", flag.ExitOnError)
+	var (
+		timeout = fs.Duration("timeout", discovery.DefaultTimeout, "how long to wait for gateways to answer")
+	)
+
+	return &ffcli.Command{
+		Name:       "discover",
+		ShortUsage: "lightctl discover [flags]",
+		ShortHelp:  "Find TRÅDFRI gateways on the local network",
+		LongHelp:   "Find TRÅDFRI gateways on the local network via mDNS, and print their addresses, for use with -gateway. The auth command does this by itself if no address is given.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			ctx, cancel := context.WithTimeout(ctx, *timeout)
+			defer cancel()
+
+			gateways, err := discovery.Browse(ctx)
+			if err != nil {
+				return fmt.Errorf("error discovering gateways: %w", err)
+			}
+
+			return writeDiscoveredGateways(stdout, root.Output, gateways)
+		},
+	}
+}
+
+// discoverGateway returns the single gateway on the local network, or an error
+// if there are none, or several.
+func discoverGateway(ctx context.Context) (discovery.Gateway, error) {
+	ctx, cancel := context.WithTimeout(ctx, discovery.DefaultTimeout)
+	defer cancel()
+
+	gateways, err := discovery.Browse(ctx)
+	if err != nil {
+		return discovery.Gateway{}, fmt.Errorf("error discovering gateways: %w", err)
+	}
+
+	switch len(gateways) {
+	case 0:
+		return discovery.Gateway{}, fmt.Errorf("no gateway found on the local network, specify one with -gateway")
+	case 1:
+		return gateways[0], nil
+	default:
+		addresses := make([]string, len(gateways))
+		for i, g := range gateways {
+			addresses[i] = g.Address()
+		}
+		return discovery.Gateway{}, fmt.Errorf("found %d gateways (%s), choose one with -gateway", len(gateways), strings.Join(addresses, ", "))
+	}
+}
+
+//
+//
+//
+
+type discoveredGatewayOutput struct {
+	Name    string `json:"name" yaml:"name"`
+	Host    string `json:"host" yaml:"host"`
+	Address string `json:"address" yaml:"address"`
+}
+
+func (o discoveredGatewayOutput) csvHeader() []string { return []string{"name", "host", "address"} }
+
+func (o discoveredGatewayOutput) csvRecord() []string { return []string{o.Name, o.Host, o.Address} }
+
+func writeDiscoveredGateways(w io.Writer, format string, gateways []discovery.Gateway) error {
+	var (
+		vs    = make([]interface{}, len(gateways))
+		texts = make([]string, len(gateways))
+	)
+	for i, g := range gateways {
+		vs[i] = discoveredGatewayOutput{Name: g.Name, Host: g.Host, Address: g.Address()}
+		texts[i] = fmt.Sprintf("%s %s (%s)", g.Name, g.Address(), g.Host)
+	}
+	return writeMany(w, format, vs, texts)
+}
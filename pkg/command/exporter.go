@@ -0,0 +1,211 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func Exporter(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl exporter", flag.ExitOnError)
+	var (
+		addr = fs.String("metrics-addr", "localhost:9585", "HTTP listen address")
+	)
+
+	return &ffcli.Command{
+		Name:       "exporter",
+		ShortUsage: "lightctl exporter [flags]",
+		ShortHelp:  "Serve Prometheus metrics of devices and groups",
+		LongHelp: "Serve Prometheus metrics of devices and groups at /metrics, e.g. to alert on low batteries or unreachable bulbs. " +
+			"The gateway is queried on every scrape, so there's no need to scrape more often than the state is of interest. " +
+			"The address may also be set in the environment, as LIGHTCTL_METRICS_ADDR, so that it doesn't collide with LIGHTCTL_ADDR of serve.",
+		FlagSet: fs,
+		Options: []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			var (
+				exporter = &exporter{client: client, errors: map[string]int{}}
+				mux      = http.NewServeMux()
+				server   = &http.Server{Addr: *addr, Handler: mux}
+				errc     = make(chan error, 1)
+			)
+			mux.Handle("/metrics", exporter)
+			go func() { errc <- server.ListenAndServe() }()
+
+			fmt.Fprintf(stderr, "serving metrics on %s/metrics\n", *addr)
+
+			select {
+			case err := <-errc:
+				return err
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				return server.Shutdown(ctx)
+			}
+		},
+	}
+}
+
+// exporter queries the gateway on every scrape. Scrapes are serialized, as
+// the gateway is easily overwhelmed.
+type exporter struct {
+	client *coap.Client
+
+	mtx    sync.Mutex
+	errors map[string]int // operation: count, since start
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	var (
+		begin   = time.Now()
+		m       = newMetrics()
+		success = 1.0
+	)
+
+	devices, err := e.client.ListDevices(r.Context())
+	if !e.count("list_devices", err) {
+		success = 0
+	}
+	for _, d := range devices {
+		labels := []string{"id", strconv.Itoa(d.ID), "name", d.Name, "model", d.DeviceInfo.Model, "firmware", d.DeviceInfo.Firmware}
+		m.add("lightctl_device_reachable", "gauge", "Whether the device is reachable by the gateway.", boolValue(d.Reachable != 0), labels...)
+		if d.LastSeen != 0 {
+			m.add("lightctl_device_last_seen_age_seconds", "gauge", "Time since the gateway last heard from the device.", begin.Sub(timeFrom(d.LastSeen)).Seconds(), labels...)
+		}
+		switch d.DeviceInfo.PowerSource {
+		case 1, 2, 3: // internal, external, or unspecified battery
+			m.add("lightctl_device_battery_percent", "gauge", "Battery level of battery-powered devices, 0..100.", float64(d.DeviceInfo.BatteryLevel), labels...)
+		}
+		if len(d.LightControl) > 0 {
+			lc := d.LightControl[0]
+			m.add("lightctl_device_on", "gauge", "Whether the light is on.", boolValue(lc.State != 0), labels...)
+			m.add("lightctl_device_dimmer", "gauge", "Dimmer level of the light, 0..254.", float64(lc.Dimmer), labels...)
+			if lc.LightMireds != 0 {
+				m.add("lightctl_device_mireds", "gauge", "Color temperature of white spectrum lights, in mireds.", float64(lc.LightMireds), labels...)
+			}
+		}
+	}
+
+	groups, err := e.client.ListGroups(r.Context())
+	if !e.count("list_groups", err) {
+		success = 0
+	}
+	for _, g := range groups {
+		labels := []string{"id", strconv.Itoa(g.ID), "name", g.Name}
+		m.add("lightctl_group_on", "gauge", "Whether the group is on.", boolValue(g.State != 0), labels...)
+		m.add("lightctl_group_dimmer", "gauge", "Dimmer level of the group, 0..254.", float64(g.Dimmer), labels...)
+	}
+
+	for _, operation := range []string{"list_devices", "list_groups"} {
+		m.add("lightctl_gateway_errors_total", "counter", "Failed requests to the gateway, including individual resources of lists.", float64(e.errors[operation]), "operation", operation)
+	}
+	m.add("lightctl_scrape_success", "gauge", "Whether all devices and groups could be fetched.", success)
+	m.add("lightctl_scrape_duration_seconds", "gauge", "Time taken to fetch all devices and groups.", time.Since(begin).Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+// count adds the failures of an operation to the error counter, and reports
+// whether it fully succeeded. A partial list counts each missing resource.
+func (e *exporter) count(operation string, err error) bool {
+	var partial *coap.PartialError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &partial):
+		e.errors[operation] += len(partial.Errors)
+	default:
+		e.errors[operation]++
+	}
+	return false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metrics accumulates samples, and writes them in the Prometheus text
+// exposition format. Families are written in the order they're first added.
+type metrics struct {
+	families []*family
+	index    map[string]*family
+}
+
+type family struct {
+	name, typ, help string
+	samples         []string
+}
+
+func newMetrics() *metrics {
+	return &metrics{index: map[string]*family{}}
+}
+
+// add adds a sample to the named family. Labels are given as name, value
+// pairs.
+func (m *metrics) add(name, typ, help string, value float64, labels ...string) {
+	f, ok := m.index[name]
+	if !ok {
+		f = &family{name: name, typ: typ, help: help}
+		m.families = append(m.families, f)
+		m.index[name] = f
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteString("}")
+	}
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	f.samples = append(f.samples, b.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func (m *metrics) writeTo(w io.Writer) error {
+	for _, f := range m.families {
+		sort.Strings(f.samples)
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, helpEscaper.Replace(f.help), f.name, f.typ); err != nil {
+			return err
+		}
+		for _, s := range f.samples {
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package command
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestExporter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New("0123456789abcdef")
+	var (
+		ceiling = g.AddDevice(testLight("Kitchen ceiling"))
+		desk    = g.AddDevice(testLight(`Desk "lamp"`))
+		remote  = coap.Device{}
+	)
+	remote.Name = "Remote"
+	remote.Reachable = 1
+	remote.DeviceInfo.Model = "TRADFRI remote control"
+	remote.DeviceInfo.PowerSource = 3
+	remote.DeviceInfo.BatteryLevel = 87
+	g.AddDevice(remote)
+	g.AddGroup(coap.Group{Resource: coap.Resource{Name: "Kitchen"}, State: 1, Dimmer: 127, GroupMembers: coap.NewGroupMembers(ceiling)})
+	root, done := testGateway(t, g)
+	defer done()
+
+	client, err := root.dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	e := &exporter{client: client, errors: map[string]int{}}
+	scrape := func() string {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/metrics", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if want, have := "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"); want != have {
+			t.Errorf("Content-Type: want %q, have %q", want, have)
+		}
+		return w.Body.String()
+	}
+
+	for _, tc := range []struct {
+		name        string
+		failing     int // ID of a device the gateway fails to serve
+		unavailable bool
+		want        []string // lines of the exposition
+		dontWant    []string // substrings of the exposition
+	}{
+		{
+			name: "all fetched",
+			want: []string{
+				"# HELP lightctl_device_reachable Whether the device is reachable by the gateway.",
+				"# TYPE lightctl_device_reachable gauge",
+				`lightctl_device_reachable{id="65536",name="Kitchen ceiling",model="",firmware=""} 1`,
+				`lightctl_device_on{id="65537",name="Desk \"lamp\"",model="",firmware=""} 1`,
+				`lightctl_device_dimmer{id="65536",name="Kitchen ceiling",model="",firmware=""} 254`,
+				`lightctl_device_mireds{id="65536",name="Kitchen ceiling",model="",firmware=""} 370`,
+				`lightctl_device_battery_percent{id="65538",name="Remote",model="TRADFRI remote control",firmware=""} 87`,
+				`lightctl_group_on{id="131073",name="Kitchen"} 1`,
+				`lightctl_group_dimmer{id="131073",name="Kitchen"} 127`,
+				"# TYPE lightctl_gateway_errors_total counter",
+				`lightctl_gateway_errors_total{operation="list_devices"} 0`,
+				`lightctl_gateway_errors_total{operation="list_groups"} 0`,
+				"lightctl_scrape_success 1",
+			},
+			dontWant: []string{
+				`lightctl_device_battery_percent{id="65536"`, // mains powered
+				`lightctl_device_on{id="65538"`,              // not a light
+			},
+		},
+		{
+			name:    "partial",
+			failing: desk,
+			want: []string{
+				`lightctl_device_reachable{id="65536",name="Kitchen ceiling",model="",firmware=""} 1`,
+				`lightctl_gateway_errors_total{operation="list_devices"} 1`,
+				`lightctl_gateway_errors_total{operation="list_groups"} 0`,
+				"lightctl_scrape_success 0",
+			},
+			dontWant: []string{`id="65537"`},
+		},
+		{
+			name:    "partial again",
+			failing: desk,
+			want: []string{
+				`lightctl_gateway_errors_total{operation="list_devices"} 2`,
+				"lightctl_scrape_success 0",
+			},
+		},
+		{
+			name:        "unavailable",
+			unavailable: true,
+			want: []string{
+				`lightctl_gateway_errors_total{operation="list_devices"} 3`,
+				`lightctl_gateway_errors_total{operation="list_groups"} 1`,
+				"lightctl_scrape_success 0",
+			},
+			dontWant: []string{"lightctl_device_reachable", "lightctl_group_on"},
+		},
+		{
+			name: "recovered",
+			want: []string{
+				`lightctl_gateway_errors_total{operation="list_devices"} 3`,
+				`lightctl_gateway_errors_total{operation="list_groups"} 1`,
+				"lightctl_scrape_success 1",
+			},
+		},
+	} {
+		if tc.failing != 0 {
+			g.SetFailing(tc.failing, true)
+		}
+		g.SetUnavailable(tc.unavailable)
+
+		have := scrape()
+
+		if tc.failing != 0 {
+			g.SetFailing(tc.failing, false)
+		}
+		g.SetUnavailable(false)
+
+		lines := map[string]bool{}
+		for _, line := range strings.Split(have, "\n") {
+			lines[line] = true
+		}
+		for _, want := range tc.want {
+			if !lines[want] {
+				t.Errorf("%s: want line %q, have\n%s", tc.name, want, have)
+			}
+		}
+		for _, dontWant := range tc.dontWant {
+			if strings.Contains(have, dontWant) {
+				t.Errorf("%s: want no %q, have\n%s", tc.name, dontWant, have)
+			}
+		}
+		if want, have := 1, strings.Count(have, "# TYPE lightctl_gateway_errors_total "); want != have {
+			t.Errorf("%s: want %d TYPE line per family, have %d", tc.name, want, have)
+		}
+	}
+}
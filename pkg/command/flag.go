@@ -1,6 +1,9 @@
 package command
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
 type optionalString struct {
 	set bool
@@ -33,3 +36,59 @@ func (o *optionalInt) Set(s string) error {
 func (o *optionalInt) String() string {
 	return strconv.Itoa(o.i)
 }
+
+type optionalFloat struct {
+	set bool
+	f   float64
+}
+
+func (o *optionalFloat) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	o.set, o.f = true, f
+	return nil
+}
+
+func (o *optionalFloat) String() string {
+	if !o.set {
+		return ""
+	}
+	return strconv.FormatFloat(o.f, 'f', -1, 64)
+}
+
+// optionalBool is a boolean flag that records whether it was set, so that
+// e.g. -disabled=false can be told apart from no flag at all.
+type optionalBool struct {
+	set bool
+	b   bool
+}
+
+func (o *optionalBool) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	o.set, o.b = true, b
+	return nil
+}
+
+func (o *optionalBool) String() string {
+	return strconv.FormatBool(o.b)
+}
+
+func (o *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+type stringSlice []string
+
+func (ss *stringSlice) Set(s string) error {
+	*ss = append(*ss, s)
+	return nil
+}
+
+func (ss *stringSlice) String() string {
+	return strings.Join(*ss, ", ")
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func Gateway(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "gateway",
+		ShortUsage: "lightctl gateway <subcommand> ...",
+		ShortHelp:  "Inspect and maintain the gateway itself",
+		Subcommands: []*ffcli.Command{
+			GatewayInfo(root, stdout, stderr),
+			GatewaySetNTP(root, stdout, stderr),
+			GatewayCheckFirmware(root, stdout, stderr),
+			GatewayReboot(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+func GatewayInfo(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "info",
+		ShortUsage: "lightctl gateway info",
+		ShortHelp:  "Get detailed information about the gateway",
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			info, err := client.GetGatewayInfo(ctx)
+			if err != nil {
+				return fmt.Errorf("error getting gateway info: %w", err)
+			}
+
+			return writeGatewayInfo(stdout, root.Output, info)
+		},
+	}
+}
+
+func GatewaySetNTP(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl gateway set-ntp", flag.ExitOnError)
+	var (
+		server = fs.String("server", "", "NTP server hostname, e.g. pool.ntp.org")
+	)
+
+	return &ffcli.Command{
+		Name:       "set-ntp",
+		ShortUsage: "lightctl gateway set-ntp [flags]",
+		ShortHelp:  "Set the NTP server of the gateway",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *server == "" {
+				return fmt.Errorf("NTP server is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			return client.SetGatewayNTPServer(ctx, *server)
+		},
+	}
+}
+
+func GatewayCheckFirmware(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "check-firmware",
+		ShortUsage: "lightctl gateway check-firmware",
+		ShortHelp:  "Make the gateway check for a firmware update",
+		LongHelp:   "Make the gateway check for, and download, a firmware update. Use gateway info to follow the update state and progress.",
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			return client.CheckGatewayFirmware(ctx)
+		},
+	}
+}
+
+func GatewayReboot(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl gateway reboot", flag.ExitOnError)
+	var (
+		yes = fs.Bool("yes", false, "confirm the reboot")
+	)
+
+	return &ffcli.Command{
+		Name:       "reboot",
+		ShortUsage: "lightctl gateway reboot -yes",
+		ShortHelp:  "Reboot the gateway",
+		LongHelp:   "Reboot the gateway. Lights keep their state, but remotes and the app are unavailable until the gateway is back, which usually takes about a minute.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if !*yes {
+				return fmt.Errorf("refusing to reboot the gateway without -yes")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			return client.RebootGateway(ctx)
+		},
+	}
+}
+
+//
+//
+//
+
+func writeGatewayInfo(w io.Writer, format string, g coap.GatewayInfo) error {
+	return writeOne(w, format, gatewayOutput{
+		ID:                g.ID,
+		Firmware:          g.Firmware,
+		NTPServer:         g.NTPServer,
+		CurrentTime:       timeFrom(g.CurrentTime),
+		FirstSetup:        timeFrom(g.FirstSetup),
+		CommissioningMode: g.CommissioningMode,
+		OTAUpdateState:    g.OTAUpdateState,
+		OTAType:           g.OTAType,
+		UpdateProgress:    g.UpdateProgress,
+		UpdateDetailsURL:  g.UpdateDetailsURL,
+		HomeKitID:         g.HomeKitID,
+		AlexaPaired:       g.AlexaPairStatus != 0,
+		GoogleHomePaired:  g.GoogleHomePairStatus != 0,
+	}, g.Long())
+}
+
+type gatewayOutput struct {
+	ID                string    `json:"id" yaml:"id"`
+	Firmware          string    `json:"firmware" yaml:"firmware"`
+	NTPServer         string    `json:"ntp_server" yaml:"ntp_server"`
+	CurrentTime       time.Time `json:"current_time" yaml:"current_time"`
+	FirstSetup        time.Time `json:"first_setup" yaml:"first_setup"`
+	CommissioningMode int       `json:"commissioning_mode" yaml:"commissioning_mode"`
+	OTAUpdateState    int       `json:"ota_update_state" yaml:"ota_update_state"`
+	OTAType           int       `json:"ota_type" yaml:"ota_type"`
+	UpdateProgress    int       `json:"update_progress" yaml:"update_progress"`
+	UpdateDetailsURL  string    `json:"update_details_url" yaml:"update_details_url"`
+	HomeKitID         string    `json:"homekit_id" yaml:"homekit_id"`
+	AlexaPaired       bool      `json:"alexa_paired" yaml:"alexa_paired"`
+	GoogleHomePaired  bool      `json:"google_home_paired" yaml:"google_home_paired"`
+}
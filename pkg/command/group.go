@@ -2,54 +2,61 @@ package command
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"net/url"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v2/ffcli"
 	"github.com/peterbourgon/lightctl/pkg/coap"
-	"github.com/peterbourgon/lightctl/pkg/config"
+	"github.com/peterbourgon/lightctl/pkg/color"
 )
 
-func Group(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func Group(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "group",
 		ShortUsage: "lightctl group <subcommand> ...",
 		ShortHelp:  "Interact with groups",
 		Subcommands: []*ffcli.Command{
-			GroupList(gateway, stdout, stderr),
-			GroupGet(gateway, stdout, stderr),
-			GroupSet(gateway, stdout, stderr),
+			GroupList(root, stdout, stderr),
+			GroupGet(root, stdout, stderr),
+			GroupSet(root, stdout, stderr),
+			GroupCreate(root, stdout, stderr),
+			GroupRename(root, stdout, stderr),
+			GroupDelete(root, stdout, stderr),
+			GroupAddMember(root, stdout, stderr),
+			GroupRemoveMember(root, stdout, stderr),
 		},
 		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func GroupList(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "list",
 		ShortUsage: "lightctl group list",
 		ShortHelp:  "List known groups",
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
-			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+			// Write whatever groups could be fetched, and report the rest.
+			groups, err := client.ListGroups(ctx)
+			var partial *coap.PartialError
+			if err != nil && !errors.As(err, &partial) {
+				return fmt.Errorf("error listing groups: %w", err)
 			}
 
-			groups, err := client.ListGroups()
-			if err != nil {
-				return fmt.Errorf("error listing groups: %w", err)
+			if err := writeGroups(stdout, root.Output, groups); err != nil {
+				return err
 			}
 
-			for _, g := range groups {
-				fmt.Fprintf(os.Stdout, "%s\n", g.Short())
+			if partial != nil {
+				return fmt.Errorf("error listing groups: %w", partial)
 			}
 
 			return nil
@@ -57,74 +64,106 @@ func GroupList(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
 	}
 }
 
-func GroupGet(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupGet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl group get", flag.ExitOnError)
 	var (
-		id = fs.Int("id", 0, "group ID")
+		id   = fs.Int("id", 0, "group ID")
+		name = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
 	)
 
 	return &ffcli.Command{
 		Name:       "get",
-		ShortUsage: "lightctl group get",
+		ShortUsage: "lightctl group get [flags]",
 		ShortHelp:  "Get detailed information about a group",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			if len(args) < 1 {
-				return flag.ErrHelp
-			}
-
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			groupID, err := resolveGroup(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			g, err := client.GetGroup(*id)
+			g, err := client.GetGroup(ctx, groupID)
 			if err != nil {
-				return fmt.Errorf("error listing groups: %w", err)
+				return fmt.Errorf("error getting group %d: %w", groupID, err)
 			}
 
-			fmt.Fprintf(stdout, "%s\n", g.Long())
-
-			return nil
+			return writeGroup(stdout, root.Output, g)
 		},
 	}
 }
 
-func GroupSet(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupSet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "set",
 		ShortUsage: "lightctl group set <subcommand>",
 		ShortHelp:  "Set properties of a group",
 		Subcommands: []*ffcli.Command{
-			GroupSetLight(gateway, stdout, stderr),
+			GroupSetLight(root, stdout, stderr),
 		},
 		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func GroupSetLight(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupSetLight(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group set light", flag.ExitOnError)
+	var (
+		id    = fs.Int("id", 0, "group ID")
+		name  = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		light lightFlags
+	)
+	fs.Var(&light.state, "state", "on, off")
+	fs.Var(&light.level, "level", "0..100")
+	fs.Var(&light.white, "white", "0..100 (0=red, 100=white)")
+	fs.Var(&light.color, "color", colorHelp)
+	fs.DurationVar(&light.transition, "transition", 0, "transition time")
+
 	return &ffcli.Command{
 		Name:       "light",
-		ShortUsage: "lightctl group set light <subcommand>",
+		ShortUsage: "lightctl group set light [flags] | <subcommand>",
 		ShortHelp:  "Set light control properties of a group",
+		LongHelp:   "Set any combination of light control properties of a group in a single request.",
+		FlagSet:    fs,
 		Subcommands: []*ffcli.Command{
-			GroupSetLightState(gateway, stdout, stderr),
-			GroupSetLightLevel(gateway, stdout, stderr),
-			GroupSetLightWhite(gateway, stdout, stderr),
+			GroupSetLightState(root, stdout, stderr),
+			GroupSetLightLevel(root, stdout, stderr),
+			GroupSetLightWhite(root, stdout, stderr),
+			GroupSetLightColor(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			if light.empty() {
+				return flag.ErrHelp
+			}
+
+			input, err := light.input()
+			if err != nil {
+				return err
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.SetLightControl(ctx, coap.RootGroups, groupID, input)
 		},
-		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
 	}
 }
 
-func GroupSetLightState(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupSetLightState(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl group set light state", flag.ExitOnError)
 	var (
 		id    = fs.Int("id", 0, "group ID")
+		name  = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
 		state = fs.String("state", "", "on, off")
 	)
 
@@ -134,25 +173,26 @@ func GroupSetLightState(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comma
 		ShortHelp:  "Set light control state of a group",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			groupID, err := resolveGroup(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			return client.SetLightControlState(coap.RootGroups, *id, *state == "on")
+			return client.SetLightControlState(ctx, coap.RootGroups, groupID, *state == "on")
 		},
 	}
 }
 
-func GroupSetLightLevel(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupSetLightLevel(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl group set light level", flag.ExitOnError)
 	var (
 		id         = fs.Int("id", 0, "group ID")
+		name       = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
 		level      = fs.Int("level", 0, "0..100")
 		transition = fs.Duration("transition", 0, "transition time")
 	)
@@ -163,33 +203,26 @@ func GroupSetLightLevel(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comma
 		ShortHelp:  "Set light control level of a group",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			groupID, err := resolveGroup(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			if *level < 0 {
-				*level = 0
-			}
-			if *level > 100 {
-				*level = 100
-			}
-
-			dimmer := int((float64(*level) / 100) * 255.0)
-			return client.SetLightControlDimmer(coap.RootGroups, *id, dimmer, *transition)
+			return client.SetLightControlDimmer(ctx, coap.RootGroups, groupID, dimmerFrom(*level), *transition)
 		},
 	}
 }
 
-func GroupSetLightWhite(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Command {
+func GroupSetLightWhite(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl group set light white", flag.ExitOnError)
 	var (
 		id         = fs.Int("id", 0, "group ID")
+		name       = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
 		white      = fs.Int("white", 0, "0..100 (0=red, 100=white)")
 		transition = fs.Duration("transition", 0, "transition time")
 	)
@@ -200,27 +233,348 @@ func GroupSetLightWhite(gateway *url.URL, stdout, stderr io.Writer) *ffcli.Comma
 		ShortHelp:  "Set light control mireds (white spectrum color) of a group",
 		FlagSet:    fs,
 		Exec: func(ctx context.Context, args []string) error {
-			c, err := config.Load()
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.SetLightControlMireds(ctx, coap.RootGroups, groupID, miredsFrom(*white), *transition)
+		},
+	}
+}
+
+func GroupSetLightColor(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group set light color", flag.ExitOnError)
+	var (
+		id         = fs.Int("id", 0, "group ID")
+		name       = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		colorStr   = fs.String("color", "", colorHelp)
+		transition = fs.Duration("transition", 0, "transition time")
+	)
+
+	return &ffcli.Command{
+		Name:       "color",
+		ShortUsage: "lightctl group set light color [flags]",
+		ShortHelp:  "Set light control color of a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			col, err := color.Parse(*colorStr)
+			if err != nil {
+				return err
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return setLightColor(ctx, client, coap.RootGroups, groupID, col, *transition)
+		},
+	}
+}
+
+func GroupCreate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group create", flag.ExitOnError)
+	var (
+		name    = fs.String("name", "", "name of the new group")
+		move    = fs.Bool("move", false, "remove the devices from their other groups")
+		devices stringSlice
+	)
+	fs.Var(&devices, "device", "device ID or name or glob pattern (repeatable)")
+
+	return &ffcli.Command{
+		Name:       "create",
+		ShortUsage: "lightctl group create [flags]",
+		ShortHelp:  "Create a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *name == "" {
+				return fmt.Errorf("group name is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			deviceIDs, err := resolveDeviceTargets(ctx, client, devices)
+			if err != nil {
+				return err
+			}
+
+			members := coap.NewGroupMembers(deviceIDs...)
+			groupID, err := client.CreateGroup(ctx, coap.GroupInput{Name: name, Members: &members})
+			if err != nil {
+				return fmt.Errorf("error creating group: %w", err)
+			}
+
+			if *move {
+				if err := removeFromOtherGroups(ctx, client, groupID, deviceIDs); err != nil {
+					return err
+				}
+			}
+
+			fmt.Fprintf(stdout, "%d\n", groupID)
+
+			return nil
+		},
+	}
+}
+
+func GroupRename(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group rename", flag.ExitOnError)
+	var (
+		id   = fs.Int("id", 0, "group ID")
+		name = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		to   = fs.String("to", "", "new name of the group")
+	)
+
+	return &ffcli.Command{
+		Name:       "rename",
+		ShortUsage: "lightctl group rename [flags]",
+		ShortHelp:  "Rename a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *to == "" {
+				return fmt.Errorf("new group name is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.UpdateGroup(ctx, groupID, coap.GroupInput{Name: to})
+		},
+	}
+}
+
+func GroupDelete(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group delete", flag.ExitOnError)
+	var (
+		id   = fs.Int("id", 0, "group ID")
+		name = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		yes  = fs.Bool("yes", false, "confirm the deletion")
+	)
+
+	return &ffcli.Command{
+		Name:       "delete",
+		ShortUsage: "lightctl group delete -yes [flags]",
+		ShortHelp:  "Delete a group, but not its member devices",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if !*yes {
+				return fmt.Errorf("refusing to delete the group without -yes")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
 			if err != nil {
-				return fmt.Errorf("error loading config: %w", err)
+				return err
+			}
+
+			return client.DeleteGroup(ctx, groupID)
+		},
+	}
+}
+
+func GroupAddMember(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group add-member", flag.ExitOnError)
+	var (
+		id      = fs.Int("id", 0, "group ID")
+		name    = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		move    = fs.Bool("move", false, "remove the devices from their other groups")
+		devices stringSlice
+	)
+	fs.Var(&devices, "device", "device ID or name or glob pattern (repeatable)")
+
+	return &ffcli.Command{
+		Name:       "add-member",
+		ShortUsage: "lightctl group add-member [flags]",
+		ShortHelp:  "Add devices to a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(devices) == 0 {
+				return fmt.Errorf("at least one device is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			deviceIDs, err := resolveDeviceTargets(ctx, client, devices)
+			if err != nil {
+				return err
+			}
+
+			if err := client.AddGroupMembers(ctx, groupID, deviceIDs...); err != nil {
+				return fmt.Errorf("error adding members to group %d: %w", groupID, err)
 			}
 
-			client, err := coap.NewClient(gateway.Scheme, gateway.Host, c.Username, c.PSK)
+			if *move {
+				return removeFromOtherGroups(ctx, client, groupID, deviceIDs)
+			}
+
+			return nil
+		},
+	}
+}
+
+func GroupRemoveMember(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl group remove-member", flag.ExitOnError)
+	var (
+		id      = fs.Int("id", 0, "group ID")
+		name    = fs.String("name", "", "group name or glob pattern, e.g. \"Kitchen*\"")
+		devices stringSlice
+	)
+	fs.Var(&devices, "device", "device ID or name or glob pattern (repeatable)")
+
+	return &ffcli.Command{
+		Name:       "remove-member",
+		ShortUsage: "lightctl group remove-member [flags]",
+		ShortHelp:  "Remove devices from a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(devices) == 0 {
+				return fmt.Errorf("at least one device is required")
+			}
+
+			client, err := root.dial(ctx)
 			if err != nil {
-				return fmt.Errorf("error dialing gateway: %w", err)
+				return err
 			}
 
-			if *white < 0 {
-				*white = 0
+			groupID, err := resolveGroup(ctx, client, *id, *name)
+			if err != nil {
+				return err
 			}
-			if *white > 100 {
-				*white = 100
+
+			deviceIDs, err := resolveDeviceTargets(ctx, client, devices)
+			if err != nil {
+				return err
 			}
 
-			red := 100 - *white
-			mireds := 250 + int((float64(red)/100)*(454-250))
+			if err := client.RemoveGroupMembers(ctx, groupID, deviceIDs...); err != nil {
+				return fmt.Errorf("error removing members from group %d: %w", groupID, err)
+			}
 
-			return client.SetLightControlMireds(coap.RootGroups, *id, mireds, *transition)
+			return nil
 		},
 	}
 }
+
+// removeFromOtherGroups removes the devices from every group except groupID,
+// so that they're only a member of that group.
+func removeFromOtherGroups(ctx context.Context, client *coap.Client, groupID int, deviceIDs []int) error {
+	groups, err := client.ListGroups(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing groups: %w", err)
+	}
+
+	for _, g := range groups {
+		if g.ID == groupID {
+			continue
+		}
+		var remove []int
+		for _, id := range deviceIDs {
+			if g.GroupMembers.Contains(id) {
+				remove = append(remove, id)
+			}
+		}
+		if len(remove) == 0 {
+			continue
+		}
+		if err := client.RemoveGroupMembers(ctx, g.ID, remove...); err != nil {
+			return fmt.Errorf("error removing members from group %d: %w", g.ID, err)
+		}
+	}
+
+	return nil
+}
+
+//
+//
+//
+
+func writeGroup(w io.Writer, format string, g coap.Group) error {
+	return writeOne(w, format, groupOutputFrom(g), g.Long())
+}
+
+func writeGroups(w io.Writer, format string, groups []coap.Group) error {
+	var (
+		vs    = make([]interface{}, len(groups))
+		texts = make([]string, len(groups))
+	)
+	for i, g := range groups {
+		vs[i], texts[i] = groupOutputFrom(g), g.Short()
+	}
+	return writeMany(w, format, vs, texts)
+}
+
+type groupOutput struct {
+	ID        int       `json:"id" yaml:"id"`
+	Name      string    `json:"name" yaml:"name"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+	On        bool      `json:"on" yaml:"on"`
+	Level     int       `json:"level" yaml:"level"`
+	ColorHex  string    `json:"color_hex" yaml:"color_hex"`
+	MoodID    int       `json:"mood_id" yaml:"mood_id"`
+	Members   []int     `json:"members" yaml:"members"`
+}
+
+// The csv format has the members as a single column of space-separated IDs.
+func (o groupOutput) csvHeader() []string {
+	return []string{"id", "name", "created_at", "on", "level", "color_hex", "mood_id", "members"}
+}
+
+func (o groupOutput) csvRecord() []string {
+	members := make([]string, len(o.Members))
+	for i, id := range o.Members {
+		members[i] = fmt.Sprint(id)
+	}
+	return []string{
+		fmt.Sprint(o.ID), o.Name, o.CreatedAt.Format(time.RFC3339), fmt.Sprint(o.On), fmt.Sprint(o.Level), o.ColorHex, fmt.Sprint(o.MoodID), strings.Join(members, " "),
+	}
+}
+
+func groupOutputFrom(g coap.Group) groupOutput {
+	members := g.GroupMembers.HSLink.IDs
+	if members == nil {
+		members = []int{}
+	}
+	return groupOutput{
+		ID:        g.ID,
+		Name:      g.Name,
+		CreatedAt: timeFrom(g.CreatedAt),
+		On:        g.State != 0,
+		Level:     g.Dimmer.Percent(),
+		ColorHex:  g.LightColorHex,
+		MoodID:    g.MoodID,
+		Members:   members,
+	}
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/color"
+)
+
+// lightFlags are the optional light control properties of the combined
+// set light commands.
+type lightFlags struct {
+	state      optionalString
+	level      optionalInt
+	white      optionalInt
+	color      optionalString
+	transition time.Duration
+}
+
+func (f *lightFlags) empty() bool {
+	return !f.state.set && !f.level.set && !f.white.set && !f.color.set
+}
+
+// input builds a single light control request from the flags that were set.
+func (f *lightFlags) input() (coap.LightControlInput, error) {
+	var in coap.LightControlInput
+
+	if f.state.set {
+		var state coap.OnOff
+		switch f.state.s {
+		case "on":
+			state = 1
+		case "off":
+			state = 0
+		default:
+			return in, fmt.Errorf("invalid state %q (on, off)", f.state.s)
+		}
+		in.State = &state
+	}
+
+	if f.level.set {
+		dimmer := coap.Percent255(dimmerFrom(f.level.i))
+		in.Dimmer = &dimmer
+	}
+
+	if f.white.set {
+		mireds := miredsFrom(f.white.i)
+		in.LightMireds = &mireds
+	}
+
+	if f.color.set {
+		c, err := color.Parse(f.color.s)
+		if err != nil {
+			return in, err
+		}
+		switch c.Model {
+		case color.Preset:
+			in.LightColorHex = &c.Hex
+		case color.HueSaturation:
+			if f.level.set {
+				return in, fmt.Errorf("level can't be combined with an hsb color, whose brightness sets the level")
+			}
+			hue, saturation := gatewayHueSaturation(c)
+			dimmer := gatewayDimmer(c)
+			in.Hue, in.Saturation, in.Dimmer = &hue, &saturation, &dimmer
+		default:
+			x, y := gatewayXY(c)
+			in.LightColorX, in.LightColorY = &x, &y
+		}
+	}
+
+	if f.level.set || f.white.set || f.color.set {
+		transition := int(f.transition.Seconds() * 10)
+		in.Transition = &transition
+	}
+
+	return in, nil
+}
+
+// dimmerFrom converts a level 0..100 to a dimmer value 0..255.
+func dimmerFrom(level int) int {
+	if level < 0 {
+		level = 0
+	}
+	if level > 100 {
+		level = 100
+	}
+	return int((float64(level) / 100) * 255.0)
+}
+
+// miredsFrom converts a white 0..100 (0=red, 100=white) to mireds 250..454.
+func miredsFrom(white int) int {
+	if white < 0 {
+		white = 0
+	}
+	if white > 100 {
+		white = 100
+	}
+	red := 100 - white
+	return 250 + int((float64(red)/100)*(454-250))
+}
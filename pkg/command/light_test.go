@@ -0,0 +1,34 @@
+package command
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLightFlagsInput(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		flags lightFlags
+		want  string // JSON of the input, empty for an error
+	}{
+		{"state", lightFlags{state: optionalString{set: true, s: "off"}}, `{"5850":0}`},
+		{"level", lightFlags{level: optionalInt{set: true, i: 50}}, `{"5851":127,"5712":0}`},
+		{"preset", lightFlags{color: optionalString{set: true, s: "warm_white"}}, `{"5706":"f1e0b5","5712":0}`},
+		{"hsb", lightFlags{color: optionalString{set: true, s: "hsb(180, 100, 50)"}}, `{"5851":127,"5707":32639,"5708":65279,"5712":0}`},
+		{"hsb and level", lightFlags{level: optionalInt{set: true, i: 50}, color: optionalString{set: true, s: "hsb(180, 100, 50)"}}, ``},
+		{"invalid state", lightFlags{state: optionalString{set: true, s: "dim"}}, ``},
+	} {
+		in, err := tc.flags.input()
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: want error, have %+v", tc.name, in)
+		case tc.want != "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case err == nil:
+			buf, _ := json.Marshal(in)
+			if have := string(buf); tc.want != have {
+				t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+			}
+		}
+	}
+}
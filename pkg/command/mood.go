@@ -0,0 +1,504 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/color"
+)
+
+const moodLightHelp = `light setting "<device ID or name> [state=on|off] [level=0..100] [white=0..100] [color=...]" (repeatable)`
+
+func Mood(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "mood",
+		ShortUsage: "lightctl mood <subcommand> ...",
+		ShortHelp:  "Interact with moods (scenes) of groups",
+		Subcommands: []*ffcli.Command{
+			MoodList(root, stdout, stderr),
+			MoodGet(root, stdout, stderr),
+			MoodActivate(root, stdout, stderr),
+			MoodCreate(root, stdout, stderr),
+			MoodUpdate(root, stdout, stderr),
+			MoodDelete(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+func MoodList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood list", flag.ExitOnError)
+	var (
+		groupID   = fs.Int("group-id", 0, "group ID (default: all groups)")
+		groupName = fs.String("group-name", "", "group name or glob pattern (default: all groups)")
+	)
+
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "lightctl mood list [flags]",
+		ShortHelp:  "List moods of one or all groups",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Write the moods of whatever groups and moods could be fetched,
+			// and report the first of the rest.
+			var (
+				groups  []coap.Group
+				partial error
+			)
+			if *groupID == 0 && *groupName == "" {
+				groups, err = client.ListGroups(ctx)
+				var p *coap.PartialError
+				if err != nil && !errors.As(err, &p) {
+					return fmt.Errorf("error listing groups: %w", err)
+				}
+				if p != nil {
+					partial = fmt.Errorf("error listing groups: %w", p)
+				}
+			} else {
+				id, err := resolveGroup(ctx, client, *groupID, *groupName)
+				if err != nil {
+					return err
+				}
+				g, err := client.GetGroup(ctx, id)
+				if err != nil {
+					return fmt.Errorf("error getting group %d: %w", id, err)
+				}
+				groups = append(groups, g)
+			}
+
+			moods := make([][]coap.Mood, len(groups))
+			for i, g := range groups {
+				moods[i], err = client.ListMoods(ctx, g.ID)
+				var p *coap.PartialError
+				if err != nil && !errors.As(err, &p) {
+					return fmt.Errorf("error listing moods of group %d: %w", g.ID, err)
+				}
+				if p != nil && partial == nil {
+					partial = fmt.Errorf("error listing moods of group %d: %w", g.ID, p)
+				}
+			}
+
+			if err := writeMoods(stdout, root.Output, groups, moods); err != nil {
+				return err
+			}
+
+			return partial
+		},
+	}
+}
+
+func MoodGet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood get", flag.ExitOnError)
+	var (
+		groupID   = fs.Int("group-id", 0, "group ID")
+		groupName = fs.String("group-name", "", "group name or glob pattern")
+		id        = fs.Int("id", 0, "mood ID")
+		name      = fs.String("name", "", "mood name or glob pattern")
+	)
+
+	return &ffcli.Command{
+		Name:       "get",
+		ShortUsage: "lightctl mood get [flags]",
+		ShortHelp:  "Get detailed information about a mood",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			gid, err := resolveGroup(ctx, client, *groupID, *groupName)
+			if err != nil {
+				return err
+			}
+
+			moodID, err := resolveMood(ctx, client, gid, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			m, err := client.GetMood(ctx, gid, moodID)
+			if err != nil {
+				return fmt.Errorf("error getting mood %d: %w", moodID, err)
+			}
+
+			return writeMood(stdout, root.Output, gid, m)
+		},
+	}
+}
+
+func MoodActivate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood activate", flag.ExitOnError)
+	var (
+		groupID   = fs.Int("group-id", 0, "group ID")
+		groupName = fs.String("group-name", "", "group name or glob pattern")
+		id        = fs.Int("id", 0, "mood ID")
+		name      = fs.String("name", "", "mood name or glob pattern")
+	)
+
+	return &ffcli.Command{
+		Name:       "activate",
+		ShortUsage: "lightctl mood activate [flags]",
+		ShortHelp:  "Activate a mood on its group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			gid, err := resolveGroup(ctx, client, *groupID, *groupName)
+			if err != nil {
+				return err
+			}
+
+			moodID, err := resolveMood(ctx, client, gid, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.ActivateMood(ctx, gid, moodID)
+		},
+	}
+}
+
+func MoodCreate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood create", flag.ExitOnError)
+	var (
+		groupID     = fs.Int("group-id", 0, "group ID")
+		groupName   = fs.String("group-name", "", "group name or glob pattern")
+		name        = fs.String("name", "", "name of the new mood")
+		fromCurrent = fs.Bool("from-current", false, "capture the current state of the group members")
+		lights      stringSlice
+	)
+	fs.Var(&lights, "light", moodLightHelp)
+
+	return &ffcli.Command{
+		Name:       "create",
+		ShortUsage: "lightctl mood create [flags]",
+		ShortHelp:  "Create a mood for a group",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *name == "" {
+				return fmt.Errorf("mood name is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			gid, err := resolveGroup(ctx, client, *groupID, *groupName)
+			if err != nil {
+				return err
+			}
+
+			moodLights, err := buildMoodLights(ctx, client, gid, *fromCurrent, lights)
+			if err != nil {
+				return err
+			}
+
+			moodID, err := client.CreateMood(ctx, gid, coap.MoodInput{Name: name, Lights: moodLights})
+			if err != nil {
+				return fmt.Errorf("error creating mood: %w", err)
+			}
+
+			fmt.Fprintf(stdout, "%d\n", moodID)
+
+			return nil
+		},
+	}
+}
+
+func MoodUpdate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood update", flag.ExitOnError)
+	var (
+		groupID     = fs.Int("group-id", 0, "group ID")
+		groupName   = fs.String("group-name", "", "group name or glob pattern")
+		id          = fs.Int("id", 0, "mood ID")
+		name        = fs.String("name", "", "mood name or glob pattern")
+		rename      = fs.String("rename", "", "new name of the mood")
+		fromCurrent = fs.Bool("from-current", false, "capture the current state of the group members")
+		lights      stringSlice
+	)
+	fs.Var(&lights, "light", moodLightHelp)
+
+	return &ffcli.Command{
+		Name:       "update",
+		ShortUsage: "lightctl mood update [flags]",
+		ShortHelp:  "Rename a mood or replace its light settings",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *rename == "" && !*fromCurrent && len(lights) == 0 {
+				return flag.ErrHelp
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			gid, err := resolveGroup(ctx, client, *groupID, *groupName)
+			if err != nil {
+				return err
+			}
+
+			moodID, err := resolveMood(ctx, client, gid, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			var input coap.MoodInput
+			if *rename != "" {
+				input.Name = rename
+			}
+			if *fromCurrent || len(lights) > 0 {
+				if input.Lights, err = buildMoodLights(ctx, client, gid, *fromCurrent, lights); err != nil {
+					return err
+				}
+			}
+
+			return client.UpdateMood(ctx, gid, moodID, input)
+		},
+	}
+}
+
+func MoodDelete(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl mood delete", flag.ExitOnError)
+	var (
+		groupID   = fs.Int("group-id", 0, "group ID")
+		groupName = fs.String("group-name", "", "group name or glob pattern")
+		id        = fs.Int("id", 0, "mood ID")
+		name      = fs.String("name", "", "mood name or glob pattern")
+	)
+
+	return &ffcli.Command{
+		Name:       "delete",
+		ShortUsage: "lightctl mood delete [flags]",
+		ShortHelp:  "Delete a mood",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			gid, err := resolveGroup(ctx, client, *groupID, *groupName)
+			if err != nil {
+				return err
+			}
+
+			moodID, err := resolveMood(ctx, client, gid, *id, *name)
+			if err != nil {
+				return err
+			}
+
+			return client.DeleteMood(ctx, gid, moodID)
+		},
+	}
+}
+
+// buildMoodLights returns the light settings of a mood, optionally starting
+// from the current state of the group members, and then applying each of the
+// light setting specs in order.
+func buildMoodLights(ctx context.Context, client *coap.Client, groupID int, fromCurrent bool, specs []string) ([]coap.MoodLight, error) {
+	var lights []coap.MoodLight
+
+	if fromCurrent {
+		g, err := client.GetGroup(ctx, groupID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting group %d: %w", groupID, err)
+		}
+		for _, id := range g.GroupMembers.HSLink.IDs {
+			d, err := client.GetDevice(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("error getting device %d: %w", id, err)
+			}
+			if len(d.LightControl) == 0 {
+				continue
+			}
+			lc := d.LightControl[0]
+			lights = append(lights, coap.MoodLight{
+				ID:            d.ID,
+				State:         lc.State,
+				Dimmer:        lc.Dimmer,
+				LightColorHex: lc.LightColorHex,
+				LightColorX:   lc.LightColorX,
+				LightColorY:   lc.LightColorY,
+				LightMireds:   lc.LightMireds,
+			})
+		}
+	}
+
+	for _, spec := range specs {
+		// The device name may contain spaces, so it's everything before the
+		// first key=value setting.
+		fields := strings.Fields(spec)
+		n := 0
+		for n < len(fields) && !strings.Contains(fields[n], "=") {
+			n++
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("invalid light setting %q", spec)
+		}
+
+		id, err := resolveDeviceTarget(ctx, client, strings.Join(fields[:n], " "))
+		if err != nil {
+			return nil, err
+		}
+
+		index := -1
+		for i := range lights {
+			if lights[i].ID == id {
+				index = i
+			}
+		}
+		if index < 0 {
+			lights = append(lights, coap.MoodLight{ID: id, State: 1, Dimmer: 254})
+			index = len(lights) - 1
+		}
+
+		if err := applyMoodLightSpec(&lights[index], fields[n:]); err != nil {
+			return nil, fmt.Errorf("invalid light setting %q: %w", spec, err)
+		}
+	}
+
+	if len(lights) == 0 {
+		return nil, fmt.Errorf("mood needs at least one light setting (-light or -from-current)")
+	}
+
+	return lights, nil
+}
+
+func applyMoodLightSpec(l *coap.MoodLight, settings []string) error {
+	for _, setting := range settings {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%q isn't key=value", setting)
+		}
+
+		switch key, val := kv[0], kv[1]; key {
+		case "state":
+			switch val {
+			case "on":
+				l.State = 1
+			case "off":
+				l.State = 0
+			default:
+				return fmt.Errorf("invalid state %q (on, off)", val)
+			}
+
+		case "level":
+			level, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid level %q", val)
+			}
+			l.Dimmer = coap.Percent255(dimmerFrom(level))
+
+		case "white":
+			white, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("invalid white %q", val)
+			}
+			l.LightMireds = miredsFrom(white)
+			l.LightColorHex, l.LightColorX, l.LightColorY = "", 0, 0
+
+		case "color":
+			c, err := color.Parse(val)
+			if err != nil {
+				return err
+			}
+			l.LightMireds = 0
+			if c.Model == color.Preset {
+				l.LightColorHex, l.LightColorX, l.LightColorY = c.Hex, 0, 0
+			} else {
+				l.LightColorHex = ""
+				l.LightColorX, l.LightColorY = gatewayXY(c)
+			}
+			if c.Model == color.HueSaturation {
+				l.Dimmer = gatewayDimmer(c)
+			}
+
+		default:
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+//
+//
+//
+
+func writeMood(w io.Writer, format string, groupID int, m coap.Mood) error {
+	return writeOne(w, format, moodOutputFrom(groupID, m), m.Long())
+}
+
+func writeMoods(w io.Writer, format string, groups []coap.Group, moods [][]coap.Mood) error {
+	var (
+		vs    []interface{}
+		texts []string
+	)
+	for i, g := range groups {
+		for _, m := range moods[i] {
+			vs = append(vs, moodOutputFrom(g.ID, m))
+			texts = append(texts, fmt.Sprintf("%d %s: %s", g.ID, g.Name, m.Short()))
+		}
+	}
+	return writeMany(w, format, vs, texts)
+}
+
+type moodOutput struct {
+	GroupID    int               `json:"group_id" yaml:"group_id"`
+	ID         int               `json:"id" yaml:"id"`
+	Name       string            `json:"name" yaml:"name"`
+	CreatedAt  time.Time         `json:"created_at" yaml:"created_at"`
+	Predefined bool              `json:"predefined" yaml:"predefined"`
+	Lights     []moodLightOutput `json:"lights" yaml:"lights"`
+}
+
+type moodLightOutput struct {
+	ID       int    `json:"id" yaml:"id"`
+	On       bool   `json:"on" yaml:"on"`
+	Level    int    `json:"level" yaml:"level"`
+	ColorHex string `json:"color_hex" yaml:"color_hex"`
+	ColorX   int    `json:"color_x" yaml:"color_x"`
+	ColorY   int    `json:"color_y" yaml:"color_y"`
+	Mireds   int    `json:"mireds" yaml:"mireds"`
+}
+
+func moodOutputFrom(groupID int, m coap.Mood) moodOutput {
+	lights := make([]moodLightOutput, len(m.Lights))
+	for i, l := range m.Lights {
+		lights[i] = moodLightOutput{
+			ID:       l.ID,
+			On:       l.State != 0,
+			Level:    l.Dimmer.Percent(),
+			ColorHex: l.LightColorHex,
+			ColorX:   l.LightColorX,
+			ColorY:   l.LightColorY,
+			Mireds:   l.LightMireds,
+		}
+	}
+	return moodOutput{
+		GroupID:    groupID,
+		ID:         m.ID,
+		Name:       m.Name,
+		CreatedAt:  timeFrom(m.CreatedAt),
+		Predefined: m.Predefined != 0,
+		Lights:     lights,
+	}
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"gopkg.in/yaml.v2"
+)
+
+// OutputFormats are the valid values of the root -output flag.
+var OutputFormats = []string{"text", "json", "yaml", "ndjson", "csv"}
+
+// ValidOutputFormat returns an error if format isn't one of OutputFormats.
+func ValidOutputFormat(format string) error {
+	for _, f := range OutputFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q", format)
+}
+
+// writeOne writes a single value in the given format. The text format writes
+// the provided text instead of the value.
+func writeOne(w io.Writer, format string, v interface{}, text string) error {
+	switch format {
+	case "text":
+		_, err := fmt.Fprintf(w, "%s\n", text)
+		return err
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case "ndjson":
+		return json.NewEncoder(w).Encode(v)
+
+	case "yaml":
+		buf, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(buf)
+		return err
+
+	case "csv":
+		return writeCSV(w, []interface{}{v})
+
+	default:
+		return fmt.Errorf("invalid output format %q", format)
+	}
+}
+
+// writeMany writes a list of values in the given format. The text and ndjson
+// formats write one line per value; the others write a single document.
+func writeMany(w io.Writer, format string, vs []interface{}, texts []string) error {
+	switch format {
+	case "text":
+		for _, text := range texts {
+			if _, err := fmt.Fprintf(w, "%s\n", text); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, v := range vs {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "csv":
+		return writeCSV(w, vs)
+
+	default:
+		if vs == nil {
+			vs = []interface{}{}
+		}
+		return writeOne(w, format, vs, "")
+	}
+}
+
+// csvRecorder is implemented by the outputs that support the csv format.
+type csvRecorder interface {
+	csvHeader() []string
+	csvRecord() []string
+}
+
+// writeCSV writes a header, and a record for each value. The values must all
+// be of the same type, which must implement csvRecorder.
+func writeCSV(w io.Writer, vs []interface{}) error {
+	cw := csv.NewWriter(w)
+	for i, v := range vs {
+		r, ok := v.(csvRecorder)
+		if !ok {
+			return fmt.Errorf("csv output isn't supported by this command")
+		}
+		if i == 0 {
+			if err := cw.Write(r.csvHeader()); err != nil {
+				return err
+			}
+		}
+		if err := cw.Write(r.csvRecord()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func timeFrom(ts coap.Timestamp) time.Time {
+	return time.Unix(int64(ts), 0).UTC()
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/config"
+)
+
+func Profile(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "profile",
+		ShortUsage: "lightctl profile <subcommand> ...",
+		ShortHelp:  "Manage the gateway profiles created by auth",
+		Subcommands: []*ffcli.Command{
+			ProfileList(root, stdout, stderr),
+			ProfileUse(root, stdout, stderr),
+			ProfileRemove(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+func ProfileList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "lightctl profile list",
+		ShortHelp:  "List all profiles, marking the default with *",
+		Exec: func(ctx context.Context, args []string) error {
+			c, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("error loading config: %w", err)
+			}
+
+			return writeProfiles(stdout, root.Output, c)
+		},
+	}
+}
+
+func ProfileUse(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl profile use", flag.ExitOnError)
+	var (
+		name = fs.String("name", "", "profile name")
+	)
+
+	return &ffcli.Command{
+		Name:       "use",
+		ShortUsage: "lightctl profile use [flags]",
+		ShortHelp:  "Make a profile the default",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *name == "" {
+				return fmt.Errorf("profile name is required")
+			}
+
+			c, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("error loading config: %w", err)
+			}
+
+			if _, ok := c.Profiles[*name]; !ok {
+				return fmt.Errorf("profile %q not found", *name)
+			}
+
+			c.Default = *name
+			return config.Save(c)
+		},
+	}
+}
+
+func ProfileRemove(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl profile remove", flag.ExitOnError)
+	var (
+		name = fs.String("name", "", "profile name")
+	)
+
+	return &ffcli.Command{
+		Name:       "remove",
+		ShortUsage: "lightctl profile remove [flags]",
+		ShortHelp:  "Remove a profile and its credentials",
+		LongHelp:   "Remove a profile and its credentials. If it was the default, there's no default until another profile is chosen with profile use, unless only one is left.",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *name == "" {
+				return fmt.Errorf("profile name is required")
+			}
+
+			c, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("error loading config: %w", err)
+			}
+
+			if _, ok := c.Profiles[*name]; !ok {
+				return fmt.Errorf("profile %q not found", *name)
+			}
+
+			delete(c.Profiles, *name)
+			if c.Default == *name {
+				c.Default = ""
+			}
+			return config.Save(c)
+		},
+	}
+}
+
+//
+//
+//
+
+type profileOutput struct {
+	Name     string `json:"name" yaml:"name"`
+	Default  bool   `json:"default" yaml:"default"`
+	Gateway  string `json:"gateway" yaml:"gateway"`
+	Username string `json:"username" yaml:"username"`
+}
+
+func (o profileOutput) csvHeader() []string {
+	return []string{"name", "default", "gateway", "username"}
+}
+
+func (o profileOutput) csvRecord() []string {
+	return []string{o.Name, fmt.Sprint(o.Default), o.Gateway, o.Username}
+}
+
+func writeProfiles(w io.Writer, format string, c config.Config) error {
+	var (
+		names = c.Names()
+		vs    = make([]interface{}, len(names))
+		texts = make([]string, len(names))
+	)
+	for i, name := range names {
+		var (
+			p       = c.Profiles[name]
+			gateway = p.Gateway
+			marker  = " "
+		)
+		if gateway == "" {
+			gateway = "(no gateway)"
+		}
+		if name == c.Default {
+			marker = "*"
+		}
+		vs[i] = profileOutput{Name: name, Default: name == c.Default, Gateway: p.Gateway, Username: p.Username}
+		texts[i] = fmt.Sprintf("%s %s %s (%s)", marker, name, gateway, p.Username)
+	}
+	return writeMany(w, format, vs, texts)
+}
@@ -0,0 +1,179 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+// resolveDevice returns the ID of the device selected by the -id and -name
+// flags. The name may be a glob pattern, and is matched case-insensitively.
+func resolveDevice(ctx context.Context, client *coap.Client, id int, name string) (int, error) {
+	if err := checkTarget("device", id, name); err != nil || id != 0 {
+		return id, err
+	}
+
+	devices, err := client.ListDevices(ctx)
+	var partial *coap.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return 0, fmt.Errorf("error listing devices: %w", err)
+	}
+
+	resources := make([]coap.Resource, len(devices))
+	for i, d := range devices {
+		resources[i] = d.Resource
+	}
+
+	return resolveName("device", resources, name, err)
+}
+
+// resolveDeviceTarget resolves a single argument that's either a device ID or
+// a device name, e.g. as part of a repeatable flag.
+func resolveDeviceTarget(ctx context.Context, client *coap.Client, target string) (int, error) {
+	if id, err := strconv.Atoi(target); err == nil {
+		return resolveDevice(ctx, client, id, "")
+	}
+	return resolveDevice(ctx, client, 0, target)
+}
+
+// resolveDeviceTargets resolves each of the targets with resolveDeviceTarget.
+func resolveDeviceTargets(ctx context.Context, client *coap.Client, targets []string) ([]int, error) {
+	ids := make([]int, len(targets))
+	for i, target := range targets {
+		id, err := resolveDeviceTarget(ctx, client, target)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// resolveGroup returns the ID of the group selected by the -id and -name
+// flags, with the same semantics as resolveDevice.
+func resolveGroup(ctx context.Context, client *coap.Client, id int, name string) (int, error) {
+	if err := checkTarget("group", id, name); err != nil || id != 0 {
+		return id, err
+	}
+
+	groups, err := client.ListGroups(ctx)
+	var partial *coap.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return 0, fmt.Errorf("error listing groups: %w", err)
+	}
+
+	resources := make([]coap.Resource, len(groups))
+	for i, g := range groups {
+		resources[i] = g.Resource
+	}
+
+	return resolveName("group", resources, name, err)
+}
+
+// resolveMood returns the ID of the mood of the given group selected by the
+// mood ID and name flags, with the same semantics as resolveDevice.
+func resolveMood(ctx context.Context, client *coap.Client, groupID, id int, name string) (int, error) {
+	if err := checkTarget("mood", id, name); err != nil || id != 0 {
+		return id, err
+	}
+
+	moods, err := client.ListMoods(ctx, groupID)
+	var partial *coap.PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return 0, fmt.Errorf("error listing moods: %w", err)
+	}
+
+	resources := make([]coap.Resource, len(moods))
+	for i, m := range moods {
+		resources[i] = m.Resource
+	}
+
+	return resolveName("mood", resources, name, err)
+}
+
+func checkTarget(kind string, id int, name string) error {
+	switch {
+	case id == 0 && name == "":
+		return fmt.Errorf("%s ID or name is required", kind)
+	case id != 0 && name != "":
+		return fmt.Errorf("%s ID and name are mutually exclusive", kind)
+	default:
+		return nil
+	}
+}
+
+// resolveName returns the ID of the single resource with the name. Names
+// that match exactly, ignoring case, take precedence; otherwise, the name is a
+// glob pattern, where * matches any sequence of characters, and ? matches any
+// single character.
+//
+// If the resources are incomplete, partial is the error of the listing. Only
+// an exact match is resolved then, as a resource that couldn't be fetched may
+// be the one that's missing, or another match of the glob pattern.
+func resolveName(kind string, resources []coap.Resource, pattern string, partial error) (int, error) {
+	var matches []coap.Resource
+	for _, r := range resources {
+		if strings.EqualFold(r.Name, pattern) {
+			matches = append(matches, r)
+		}
+	}
+	if len(matches) == 0 && partial != nil {
+		return 0, fmt.Errorf("can't resolve %s name %q: %w", kind, pattern, partial)
+	}
+	if len(matches) == 0 {
+		lower := strings.ToLower(pattern)
+		for _, r := range resources {
+			if globMatch(lower, strings.ToLower(r.Name)) {
+				matches = append(matches, r)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no %s matches name %q", kind, pattern)
+	case 1:
+		return matches[0].ID, nil
+	default:
+		candidates := make([]string, len(matches))
+		for i, r := range matches {
+			candidates[i] = fmt.Sprintf("%d (%s)", r.ID, r.Name)
+		}
+		return 0, fmt.Errorf("name %q is ambiguous: matches %ss %s", pattern, kind, strings.Join(candidates, ", "))
+	}
+}
+
+// globMatch reports whether the name matches the pattern, where * matches any
+// sequence of characters, including none, and ? matches any single character.
+// Unlike path.Match, there are no separators, character classes, or escapes.
+func globMatch(pattern, name string) bool {
+	var (
+		p, n         = []rune(pattern), []rune(name)
+		pi, ni       int
+		star, starNi = -1, 0 // position of the last *, and where it matched from
+	)
+	for ni < len(n) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == n[ni]):
+			pi++
+			ni++
+		case pi < len(p) && p[pi] == '*':
+			star, starNi = pi, ni
+			pi++
+		case star >= 0:
+			// Let the last * match one more character, and try again.
+			starNi++
+			pi, ni = star+1, starNi
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
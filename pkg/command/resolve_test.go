@@ -0,0 +1,77 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"kitchen", "kitchen", true},
+		{"kitchen", "kitchen ceiling", false},
+		{"kitchen*", "kitchen ceiling", true},
+		{"kitchen*", "kitchen", true},
+		{"*ceiling", "kitchen ceiling", true},
+		{"k*n c*g", "kitchen ceiling", true},
+		{"k?tchen", "kitchen", true},
+		{"k?tchen", "ktchen", false},
+		{"*", "", true},
+		{"*/*", "hall/upstairs", true},
+		{"hall*", "hall/upstairs", true},
+		{"[a]*", "[a] lamp", true},
+		{"[a]*", "a lamp", false},
+		{"*a*b", "aXbXab", true},
+		{"*a*b", "aXbXa", false},
+	} {
+		if have := globMatch(tc.pattern, tc.name); tc.want != have {
+			t.Errorf("globMatch(%q, %q): want %v, have %v", tc.pattern, tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestResolveName(t *testing.T) {
+	resources := []coap.Resource{
+		{ID: 1, Name: "Kitchen ceiling"},
+		{ID: 2, Name: "Kitchen counter"},
+		{ID: 3, Name: "Kitchen*"},
+		{ID: 4, Name: "Hall/upstairs"},
+		{ID: 5, Name: "Lamp [1]"},
+	}
+
+	// Device 6 couldn't be fetched.
+	partial := &coap.PartialError{Kind: "device", Total: 6, Errors: map[int]error{6: coap.ErrTimeout}}
+
+	for _, tc := range []struct {
+		pattern string
+		partial error
+		want    int // 0 for an error
+	}{
+		{"kitchen ceiling", nil, 1},
+		{"KITCHEN COUNTER", nil, 2},
+		{"kitchen*", nil, 3}, // exact match takes precedence
+		{"kitchen c*", nil, 0},
+		{"*ceiling", nil, 1},
+		{"hall*", nil, 4},
+		{"lamp [1]", nil, 5},
+		{"lamp ?1?", nil, 5},
+		{"bedroom", nil, 0},
+		{"kitchen ceiling", partial, 1},
+		{"kitchen*", partial, 3},
+		{"*ceiling", partial, 0}, // device 6 may match too
+		{"bedroom", partial, 0},  // device 6 may be the bedroom
+	} {
+		have, err := resolveName("device", resources, tc.pattern, tc.partial)
+		switch {
+		case tc.want == 0 && err == nil:
+			t.Errorf("%q: want error, have ID %d", tc.pattern, have)
+		case tc.want != 0 && err != nil:
+			t.Errorf("%q: %v", tc.pattern, err)
+		case tc.want != have:
+			t.Errorf("%q: want ID %d, have %d", tc.pattern, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/config"
+)
+
+// RootConfig holds the root flags, which are shared by all subcommands. It's
+// populated once the root flags are parsed, before any subcommand runs.
+type RootConfig struct {
+	Gateway string // overrides the gateway address of the profile
+	Profile string // empty for the default profile
+	Output  string
+	Trace   io.Writer // if set, every request to the gateway is traced to it
+}
+
+// dial loads the config, and establishes a session with the gateway of the
+// selected profile.
+func (rc *RootConfig) dial(ctx context.Context) (*coap.Client, error) {
+	c, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
+	name, p, err := c.Profile(rc.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Auth always saves an address, and Load converts older config files
+	// with the address they used, so only an edited config file lacks it.
+	if rc.Gateway == "" && p.Gateway == "" {
+		return nil, fmt.Errorf("profile %q has no gateway address, give one with -gateway, or run auth again", name)
+	}
+
+	u, err := rc.gatewayURL(p)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := coap.NewClient(ctx, u.Scheme, u.Host, p.Username, p.PSK, rc.clientOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing gateway: %w", err)
+	}
+
+	return client, nil
+}
+
+// gatewayURL returns the address from the -gateway flag, or else from the
+// profile.
+func (rc *RootConfig) gatewayURL(p config.Profile) (*url.URL, error) {
+	address := rc.Gateway
+	if address == "" {
+		address = p.Gateway
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing gateway: %w", err)
+	}
+
+	return u, nil
+}
+
+func (rc *RootConfig) clientOptions() []coap.Option {
+	var options []coap.Option
+	if rc.Trace != nil {
+		var mtx sync.Mutex // requests may be concurrent
+		options = append(options, coap.WithTracer(func(t coap.Trace) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			fmt.Fprintf(rc.Trace, "# %s\n", t)
+		}))
+	}
+	return options
+}
@@ -0,0 +1,288 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/schedule"
+)
+
+// maxLateness is how late a rule may still fire, e.g. after the machine was
+// suspended. Later firings are skipped, rather than e.g. turning the porch
+// light off at breakfast.
+const maxLateness = 5 * time.Minute
+
+func Schedule(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "schedule",
+		ShortUsage: "lightctl schedule <subcommand> ...",
+		ShortHelp:  "Set lights by rules with times of day, solar events, or cron expressions",
+		LongHelp: "Set lights by rules with times of day, solar events, or cron expressions, e.g.\n\n" +
+			"  at sunset-30m set group Porch on 80%\n" +
+			"  weekdays 06:45 fade Bedroom to 100% over 15m\n" +
+			"  cron 0 23 * * * set group Porch off\n\n" +
+			"Unlike the smart tasks of the gateway, the rules are executed by lightctl, which must keep running. " +
+			"The flags may also be set in the environment, as LIGHTCTL_RULES, LIGHTCTL_LATITUDE, and LIGHTCTL_LONGITUDE.",
+		Subcommands: []*ffcli.Command{
+			ScheduleRun(root, stdout, stderr),
+			ScheduleList(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+// scheduleFlags are the flags shared by the schedule commands.
+type scheduleFlags struct {
+	rules    string
+	location locationFlags
+}
+
+func (f *scheduleFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.rules, "rules", "", "rules file, one rule per line")
+	f.location.register(fs)
+}
+
+// read parses the rules file. The location is only required if there are
+// rules with solar events.
+func (f *scheduleFlags) read() ([]schedule.Rule, error) {
+	if f.rules == "" {
+		return nil, fmt.Errorf("rules file is required")
+	}
+
+	var location *schedule.Location
+	if f.location.latitude.set || f.location.longitude.set {
+		latitude, longitude, err := f.location.values()
+		if err != nil {
+			return nil, err
+		}
+		location = &schedule.Location{Latitude: latitude, Longitude: longitude}
+	}
+
+	file, err := os.Open(f.rules)
+	if err != nil {
+		return nil, fmt.Errorf("error opening rules file: %w", err)
+	}
+	defer file.Close()
+
+	rules, err := schedule.Parse(file, location)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rules file: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules in %s", f.rules)
+	}
+
+	return rules, nil
+}
+
+func ScheduleRun(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl schedule run", flag.ExitOnError)
+	var flags scheduleFlags
+	flags.register(fs)
+
+	return &ffcli.Command{
+		Name:       "run",
+		ShortUsage: "lightctl schedule run [flags]",
+		ShortHelp:  "Execute the rules as they fire, until interrupted",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
+		Exec: func(ctx context.Context, args []string) error {
+			rules, err := flags.read()
+			if err != nil {
+				return err
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			groupIDs := make([]int, len(rules))
+			for i, r := range rules {
+				if groupIDs[i], err = resolveGroup(ctx, client, 0, r.Action.Group); err != nil {
+					return fmt.Errorf("line %d: %w", r.Line, err)
+				}
+			}
+
+			var (
+				now  = time.Now()
+				next = make([]time.Time, len(rules))
+			)
+			for i, r := range rules {
+				next[i] = r.Next(now)
+			}
+
+			fmt.Fprintf(stderr, "running %d rule%s\n", len(rules), plural(len(rules)))
+
+			for {
+				// Wake up at least every minute, to notice changes of the wall
+				// clock, e.g. after the machine was suspended.
+				wait := time.Minute
+				for _, t := range next {
+					if d := time.Until(t); !t.IsZero() && d < wait {
+						wait = d
+					}
+				}
+
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				}
+
+				now := time.Now()
+				for i, r := range rules {
+					if next[i].IsZero() || next[i].After(now) {
+						continue
+					}
+
+					var result string
+					if late := now.Sub(next[i]); late > maxLateness {
+						result = fmt.Sprintf("skipped, %s late", late.Round(time.Second))
+					} else if err := client.SetLightControl(ctx, coap.RootGroups, groupIDs[i], actionInput(r.Action)); err != nil {
+						result = fmt.Sprintf("error: %v", err)
+					} else {
+						result = "ok"
+					}
+					fmt.Fprintf(stdout, "%s line %d: %s: %s\n", now.Format(time.RFC3339), r.Line, r, result)
+
+					next[i] = r.Next(now)
+				}
+			}
+		},
+	}
+}
+
+func ScheduleList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl schedule list", flag.ExitOnError)
+	var (
+		flags scheduleFlags
+		count = fs.Int("count", 1, "number of upcoming firings per rule")
+	)
+	flags.register(fs)
+
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "lightctl schedule list [flags]",
+		ShortHelp:  "List the upcoming firings of the rules, in order",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
+		Exec: func(ctx context.Context, args []string) error {
+			rules, err := flags.read()
+			if err != nil {
+				return err
+			}
+
+			if *count < 1 {
+				return fmt.Errorf("count must be at least 1")
+			}
+
+			var firings []firing
+			for _, r := range rules {
+				t := time.Now()
+				for i := 0; i < *count; i++ {
+					if t = r.Next(t); t.IsZero() {
+						firings = append(firings, firing{rule: r})
+						break
+					}
+					firings = append(firings, firing{rule: r, next: t})
+				}
+			}
+
+			// Rules that never fire go last.
+			sort.SliceStable(firings, func(i, j int) bool {
+				a, b := firings[i].next, firings[j].next
+				if a.IsZero() || b.IsZero() {
+					return !a.IsZero() && b.IsZero()
+				}
+				return a.Before(b)
+			})
+
+			return writeFirings(stdout, root.Output, firings)
+		},
+	}
+}
+
+// firing is an upcoming firing of a rule. The time is zero if the rule never
+// fires.
+type firing struct {
+	rule schedule.Rule
+	next time.Time
+}
+
+// actionInput builds the light control request for a rule's action.
+func actionInput(a schedule.Action) coap.LightControlInput {
+	var in coap.LightControlInput
+
+	if a.On != nil {
+		state := coap.OnOff(0)
+		if *a.On {
+			state = 1
+		}
+		in.State = &state
+	}
+
+	if a.Level != nil {
+		dimmer := coap.Percent255(dimmerFrom(*a.Level))
+		in.Dimmer = &dimmer
+	}
+
+	if a.White != nil {
+		mireds := miredsFrom(*a.White)
+		in.LightMireds = &mireds
+	}
+
+	if a.Level != nil || a.White != nil {
+		transition := int(a.Transition.Seconds() * 10)
+		in.Transition = &transition
+	}
+
+	return in
+}
+
+//
+//
+//
+
+type firingOutput struct {
+	Next *time.Time `json:"next" yaml:"next"` // nil if the rule never fires
+	Line int        `json:"line" yaml:"line"`
+	Rule string     `json:"rule" yaml:"rule"`
+}
+
+func (o firingOutput) csvHeader() []string { return []string{"next", "line", "rule"} }
+
+func (o firingOutput) csvRecord() []string {
+	var next string
+	if o.Next != nil {
+		next = o.Next.Format(time.RFC3339)
+	}
+	return []string{next, fmt.Sprint(o.Line), o.Rule}
+}
+
+func writeFirings(w io.Writer, format string, firings []firing) error {
+	var (
+		vs    = make([]interface{}, len(firings))
+		texts = make([]string, len(firings))
+	)
+	for i, f := range firings {
+		o := firingOutput{Line: f.rule.Line, Rule: f.rule.String()}
+		when := "never"
+		if next := f.next; !next.IsZero() {
+			o.Next = &next
+			when = next.Format("2006-01-02 15:04:05 MST")
+		}
+		vs[i], texts[i] = o, fmt.Sprintf("%s  line %d: %s", when, o.Line, o.Rule)
+	}
+	return writeMany(w, format, vs, texts)
+}
@@ -0,0 +1,306 @@
+package command
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/ff/v2"
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func Serve(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl serve", flag.ExitOnError)
+	var (
+		addr  = fs.String("addr", "localhost:8080", "HTTP listen address")
+		token = fs.String("token", "", "if set, require an Authorization: Bearer <token> header")
+	)
+
+	return &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "lightctl serve [flags]",
+		ShortHelp:  "Serve an HTTP JSON API for devices and groups",
+		LongHelp: "Serve an HTTP JSON API for devices and groups, so that clients don't need to speak CoAP, or know the gateway credentials. " +
+			"All requests share a single session with the gateway. The flags may also be set in the environment, as LIGHTCTL_ADDR and LIGHTCTL_TOKEN.\n\n" +
+			"  GET /devices              list devices\n" +
+			"  GET /devices/{id}         get a device\n" +
+			"  PUT /devices/{id}/light   set light control properties of a device\n" +
+			"  GET /groups               list groups\n" +
+			"  GET /groups/{id}          get a group\n" +
+			"  PUT /groups/{id}/light    set light control properties of a group\n\n" +
+			"The light requests take any combination of the properties of the set light commands, e.g.\n\n" +
+			"  {\"state\": \"on\", \"level\": 80, \"white\": 50, \"color\": \"#ff8800\", \"transition\": \"2s\"}",
+		FlagSet: fs,
+		Options: []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			var (
+				handler = &apiHandler{client: client, token: *token}
+				server  = &http.Server{Addr: *addr, Handler: logRequests(handler, stderr)}
+				errc    = make(chan error, 1)
+			)
+			go func() { errc <- server.ListenAndServe() }()
+
+			fmt.Fprintf(stderr, "listening on %s\n", *addr)
+
+			select {
+			case err := <-errc:
+				return err
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				return server.Shutdown(ctx)
+			}
+		},
+	}
+}
+
+// apiHandler serves the HTTP API, with the client shared by all requests.
+type apiHandler struct {
+	client *coap.Client
+	token  string
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+
+	// /{kind}, /{kind}/{id}, or /{kind}/{id}/light
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var root int
+	switch parts[0] {
+	case "devices":
+		root = coap.RootDevices
+	case "groups":
+		root = coap.RootGroups
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	var id int
+	if len(parts) > 1 {
+		var err error
+		if id, err = strconv.Atoi(parts[1]); err != nil || id <= 0 {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("invalid ID %q", parts[1]))
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && allowMethod(w, r, http.MethodGet):
+		h.list(w, r, root)
+	case len(parts) == 2 && allowMethod(w, r, http.MethodGet):
+		h.get(w, r, root, id)
+	case len(parts) == 3 && parts[2] == "light" && allowMethod(w, r, http.MethodPut):
+		h.setLight(w, r, root, id)
+	case len(parts) > 3 || (len(parts) == 3 && parts[2] != "light"):
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// allowMethod writes a 405 Method Not Allowed response, and returns false, if
+// the request doesn't have the given method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func (h *apiHandler) list(w http.ResponseWriter, r *http.Request, root int) {
+	var (
+		outputs []interface{}
+		err     error
+	)
+	switch root {
+	case coap.RootDevices:
+		var devices []coap.Device
+		devices, err = h.client.ListDevices(r.Context())
+		for _, d := range devices {
+			outputs = append(outputs, deviceOutputFrom(d))
+		}
+	default:
+		var groups []coap.Group
+		groups, err = h.client.ListGroups(r.Context())
+		for _, g := range groups {
+			outputs = append(outputs, groupOutputFrom(g))
+		}
+	}
+
+	// Return whatever could be fetched, like the list commands.
+	var partial *coap.PartialError
+	switch {
+	case errors.As(err, &partial):
+		w.Header().Set("Warning", "199 lightctl "+strconv.Quote(partial.Error()))
+	case err != nil:
+		writeAPIError(w, statusFor(err), err)
+		return
+	}
+
+	if outputs == nil {
+		outputs = []interface{}{}
+	}
+	writeAPIResponse(w, http.StatusOK, outputs)
+}
+
+func (h *apiHandler) get(w http.ResponseWriter, r *http.Request, root, id int) {
+	var (
+		output interface{}
+		err    error
+	)
+	switch root {
+	case coap.RootDevices:
+		var d coap.Device
+		d, err = h.client.GetDevice(r.Context(), id)
+		output = deviceOutputFrom(d)
+	default:
+		var g coap.Group
+		g, err = h.client.GetGroup(r.Context(), id)
+		output = groupOutputFrom(g)
+	}
+	if err != nil {
+		writeAPIError(w, statusFor(err), err)
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, output)
+}
+
+// lightRequest is the body of the light requests. The properties mirror the
+// flags of the set light commands.
+type lightRequest struct {
+	State      *string `json:"state"` // on, off
+	Level      *int    `json:"level"` // 0..100
+	White      *int    `json:"white"` // 0..100 (0=red, 100=white)
+	Color      *string `json:"color"`
+	Transition string  `json:"transition"` // e.g. 2s
+}
+
+func (h *apiHandler) setLight(w http.ResponseWriter, r *http.Request, root, id int) {
+	var req lightRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	var light lightFlags
+	if req.State != nil {
+		light.state = optionalString{set: true, s: *req.State}
+	}
+	if req.Level != nil {
+		light.level = optionalInt{set: true, i: *req.Level}
+	}
+	if req.White != nil {
+		light.white = optionalInt{set: true, i: *req.White}
+	}
+	if req.Color != nil {
+		light.color = optionalString{set: true, s: *req.Color}
+	}
+	if req.Transition != "" {
+		d, err := time.ParseDuration(req.Transition)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid transition: %w", err))
+			return
+		}
+		light.transition = d
+	}
+	if light.empty() {
+		writeAPIError(w, http.StatusBadRequest, errors.New("nothing to set (state, level, white, color)"))
+		return
+	}
+
+	input, err := light.input()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.client.SetLightControl(r.Context(), root, id, input); err != nil {
+		writeAPIError(w, statusFor(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statusFor maps errors of the gateway to HTTP status codes.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, coap.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, coap.ErrBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, coap.ErrServiceUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, coap.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIResponse(w, status, struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	})
+}
+
+// logRequests logs each request, with its response status and duration.
+func logRequests(next http.Handler, w io.Writer) http.Handler {
+	var mtx sync.Mutex // requests are concurrent
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var (
+			begin = time.Now()
+			srw   = &statusResponseWriter{ResponseWriter: rw, status: http.StatusOK}
+		)
+		next.ServeHTTP(srw, r)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		fmt.Fprintf(w, "%s %s %s %d %s\n", begin.Format(time.RFC3339), r.Method, r.URL.Path, srw.status, time.Since(begin).Round(time.Microsecond))
+	})
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestAPIHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New("0123456789abcdef")
+	var (
+		ceiling = g.AddDevice(testLight("Kitchen ceiling"))
+		hallway = g.AddDevice(testLight("Hallway"))
+		kitchen = g.AddGroup(coap.Group{Resource: coap.Resource{Name: "Kitchen"}, GroupMembers: coap.NewGroupMembers(ceiling)})
+	)
+	root, done := testGateway(t, g)
+	defer done()
+
+	client, err := root.dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	handler := &apiHandler{client: client, token: "hunter2"}
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		failing    int // ID of a resource the gateway fails to serve
+		wantStatus int
+		wantHeader string // "Key: value", if any
+		wantBody   string // substring
+	}{
+		{"list devices", "GET", "/devices", "hunter2", "", 0, 200, "", `"name": "Hallway"`},
+		{"list groups", "GET", "/groups/", "hunter2", "", 0, 200, "", `"name": "Kitchen"`},
+		{"get device", "GET", fmt.Sprintf("/devices/%d", ceiling), "hunter2", "", 0, 200, "", `"name": "Kitchen ceiling"`},
+		{"get group", "GET", fmt.Sprintf("/groups/%d", kitchen), "hunter2", "", 0, 200, "", `"name": "Kitchen"`},
+		{"partial list", "GET", "/devices", "hunter2", "", hallway, 200, `Warning: 199 lightctl "couldn't get 1 of 2 devices`, `"name": "Kitchen ceiling"`},
+		{"no token", "GET", "/devices", "", "", 0, 401, "WWW-Authenticate: Bearer", "missing or invalid token"},
+		{"wrong token", "GET", "/devices", "hunter3", "", 0, 401, "WWW-Authenticate: Bearer", "missing or invalid token"},
+		{"unknown kind", "GET", "/moods", "hunter2", "", 0, 404, "", "not found"},
+		{"invalid ID", "GET", "/devices/kitchen", "hunter2", "", 0, 404, "", `invalid ID \"kitchen\"`},
+		{"unknown subresource", "GET", fmt.Sprintf("/devices/%d/color", ceiling), "hunter2", "", 0, 404, "", "not found"},
+		{"too deep", "PUT", fmt.Sprintf("/devices/%d/light/level", ceiling), "hunter2", "", 0, 404, "", "not found"},
+		{"list method", "POST", "/devices", "hunter2", "", 0, 405, "Allow: GET", "method POST not allowed"},
+		{"get method", "DELETE", fmt.Sprintf("/groups/%d", kitchen), "hunter2", "", 0, 405, "Allow: GET", "method DELETE not allowed"},
+		{"light method", "GET", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", "", 0, 405, "Allow: PUT", "method GET not allowed"},
+		{"unknown device", "GET", "/devices/12345", "hunter2", "", 0, 404, "", "NotFound"},
+		{"unavailable device", "GET", fmt.Sprintf("/devices/%d", ceiling), "hunter2", "", ceiling, 503, "", "ServiceUnavailable"},
+		{"set device light", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"level": 50, "transition": "2s"}`, 0, 204, "", ""},
+		{"set group light", "PUT", fmt.Sprintf("/groups/%d/light", kitchen), "hunter2", `{"state": "on", "color": "warm_white"}`, 0, 204, "", ""},
+		{"set unknown device light", "PUT", "/devices/12345/light", "hunter2", `{"state": "off"}`, 0, 404, "", "NotFound"},
+		{"invalid body", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"level": "high"}`, 0, 400, "", "invalid request body"},
+		{"unknown property", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"brightness": 50}`, 0, 400, "", "invalid request body"},
+		{"nothing to set", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"transition": "2s"}`, 0, 400, "", "nothing to set"},
+		{"invalid transition", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"level": 50, "transition": "soon"}`, 0, 400, "", "invalid transition"},
+		{"invalid state", "PUT", fmt.Sprintf("/devices/%d/light", ceiling), "hunter2", `{"state": "dim"}`, 0, 400, "", `invalid state \"dim\"`},
+	} {
+		if tc.failing != 0 {
+			g.SetFailing(tc.failing, true)
+		}
+
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)).WithContext(ctx)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if tc.failing != 0 {
+			g.SetFailing(tc.failing, false)
+		}
+
+		if want, have := tc.wantStatus, w.Code; want != have {
+			t.Errorf("%s: want status %d, have %d (%s)", tc.name, want, have, strings.TrimSpace(w.Body.String()))
+		}
+		if tc.wantHeader != "" {
+			kv := strings.SplitN(tc.wantHeader, ": ", 2)
+			if want, have := kv[1], w.Header().Get(kv[0]); !strings.HasPrefix(have, want) {
+				t.Errorf("%s: want %s header %q, have %q", tc.name, kv[0], want, have)
+			}
+		}
+		if want, have := tc.wantBody, w.Body.String(); !strings.Contains(have, want) {
+			t.Errorf("%s: want %q in body, have %q", tc.name, want, have)
+		}
+	}
+
+	d, _ := g.Device(ceiling)
+	if want, have := coap.Percent255(127), d.LightControl[0].Dimmer; want != have {
+		t.Errorf("after setting the device light: want dimmer %d, have %d", want, have)
+	}
+	if want, have := "f1e0b5", d.LightControl[0].LightColorHex; want != have {
+		t.Errorf("after setting the group light: want color %s, have %s", want, have)
+	}
+}
+
+func TestStatusFor(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{coap.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("error getting device 65536: %w", coap.ErrNotFound), http.StatusNotFound},
+		{coap.ErrBadRequest, http.StatusBadRequest},
+		{coap.ErrServiceUnavailable, http.StatusServiceUnavailable},
+		{coap.ErrTimeout, http.StatusGatewayTimeout},
+		{coap.ErrUnauthorized, http.StatusBadGateway},
+		{errors.New("connection refused"), http.StatusBadGateway},
+	} {
+		if want, have := tc.want, statusFor(tc.err); want != have {
+			t.Errorf("%v: want %d, have %d", tc.err, want, have)
+		}
+	}
+}
@@ -5,55 +5,193 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/peterbourgon/ff/v2"
 	"github.com/peterbourgon/ff/v2/ffcli"
-	"github.com/sixdouglas/suncalc"
+	"github.com/peterbourgon/lightctl/pkg/circadian"
 )
 
-func Sun(stdout, stderr io.Writer) *ffcli.Command {
+// Default location of the sun commands, Berlin.
+const (
+	defaultLatitude  = 52.520008
+	defaultLongitude = 13.404954
+)
+
+// locationFlags are the coordinates taken by the sun and schedule commands.
+// If defaults is set, they default to Berlin, as they always have for sun.
+type locationFlags struct {
+	latitude  optionalFloat
+	longitude optionalFloat
+	defaults  bool
+}
+
+func (l *locationFlags) register(fs *flag.FlagSet) {
+	latitudeHelp, longitudeHelp := "latitude in decimal form, e.g. 52.520008", "longitude in decimal form, e.g. 13.404954"
+	if l.defaults {
+		latitudeHelp = fmt.Sprintf("latitude in decimal form (default %v)", defaultLatitude)
+		longitudeHelp = fmt.Sprintf("longitude in decimal form (default %v)", defaultLongitude)
+	}
+	fs.Var(&l.latitude, "latitude", latitudeHelp)
+	fs.Var(&l.longitude, "longitude", longitudeHelp)
+}
+
+func (l *locationFlags) values() (latitude, longitude float64, err error) {
+	if l.defaults && !l.latitude.set && !l.longitude.set {
+		return defaultLatitude, defaultLongitude, nil
+	}
+
+	switch {
+	case !l.latitude.set || !l.longitude.set:
+		return 0, 0, fmt.Errorf("latitude and longitude are required")
+	case l.latitude.f < -90 || l.latitude.f > 90:
+		return 0, 0, fmt.Errorf("invalid latitude %v", l.latitude.f)
+	case l.longitude.f < -180 || l.longitude.f > 180:
+		return 0, 0, fmt.Errorf("invalid longitude %v", l.longitude.f)
+	default:
+		return l.latitude.f, l.longitude.f, nil
+	}
+}
+
+func Sun(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
 	fs := flag.NewFlagSet("lightctl sun", flag.ExitOnError)
 	var (
-		latitude  = fs.Float64("latitude", 52.520008, "latitude in decimal form")
-		longitude = fs.Float64("longitude", 13.404954, "longitude in decimal form")
-		dateStr   = fs.String("date", time.Now().Truncate(time.Minute).Format(time.RFC3339), "date to calculate for (RFC3339)")
+		location = locationFlags{defaults: true}
+		dateStr  = fs.String("date", "", "date to calculate for (RFC3339), default now")
 	)
+	location.register(fs)
+
 	return &ffcli.Command{
 		Name:       "sun",
-		ShortUsage: "lightctl sun [flags]",
+		ShortUsage: "lightctl sun [flags] | <subcommand>",
 		ShortHelp:  "Print height of sun",
+		LongHelp:   "Print height of sun, from 0 at sunrise and sunset, to 100 at solar noon. The location defaults to Berlin, and may also be set in the environment, as LIGHTCTL_LATITUDE and LIGHTCTL_LONGITUDE.",
+		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
+		Subcommands: []*ffcli.Command{
+			SunEvents(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error {
+			latitude, longitude, err := location.values()
+			if err != nil {
+				return err
+			}
+
+			date := time.Now().Truncate(time.Minute)
+			if *dateStr != "" {
+				if date, err = time.Parse(time.RFC3339, *dateStr); err != nil {
+					return fmt.Errorf("error parsing date: %w", err)
+				}
+			}
+
+			height := int(circadian.SunHeight(date, latitude, longitude) * 100)
+			return writeOne(stdout, root.Output, sunHeightOutput{Time: date, Height: height}, strconv.Itoa(height))
+		},
+	}
+}
+
+func SunEvents(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl sun events", flag.ExitOnError)
+	var (
+		location locationFlags
+		dateStr  = fs.String("date", "", "first date (YYYY-MM-DD), default today")
+		days     = fs.Int("days", 1, "number of days, starting with the first date")
+		timezone = fs.String("timezone", "", "time zone of dates and times, e.g. Europe/Berlin, default local")
+	)
+	location.register(fs)
+
+	return &ffcli.Command{
+		Name:       "events",
+		ShortUsage: "lightctl sun events [flags]",
+		ShortHelp:  "Print the times of sunrise, sunset, and other solar events",
+		LongHelp:   "Print the times of all solar events of one or more days: " + strings.Join(circadian.EventNames, ", ") + ". Events that don't occur on a day, e.g. night during the summer in the far north, are omitted. The location is required, and may also be set in the environment, as LIGHTCTL_LATITUDE and LIGHTCTL_LONGITUDE.",
 		FlagSet:    fs,
+		Options:    []ff.Option{ff.WithEnvVarPrefix("LIGHTCTL")},
 		Exec: func(ctx context.Context, args []string) error {
-			date, err := time.Parse(time.RFC3339, *dateStr)
+			latitude, longitude, err := location.values()
 			if err != nil {
-				return fmt.Errorf("error parsing date: %w", err)
+				return err
 			}
 
-			var (
-				times   = suncalc.GetTimes(date, *latitude, *longitude)
-				sunrise = times[suncalc.Sunrise].Time
-				noon    = times[suncalc.SolarNoon].Time
-				sunset  = times[suncalc.Sunset].Time
-				percent float64
-			)
-			switch {
-			case date.Before(sunrise):
-				percent = 0.0
-			case date.Before(noon):
-				percent = float64(date.Sub(sunrise)) / float64(noon.Sub(sunrise))
-			case date.Equal(noon):
-				percent = 1.0
-			case date.Before(sunset):
-				percent = 1 - (float64(date.Sub(noon)) / float64(sunset.Sub(noon)))
-			case date.After(sunset):
-				percent = 0.0
-			default:
-				return fmt.Errorf("invalid date")
+			loc := time.Local
+			if *timezone != "" {
+				if loc, err = time.LoadLocation(*timezone); err != nil {
+					return fmt.Errorf("error loading timezone: %w", err)
+				}
 			}
 
-			fmt.Fprintf(stdout, "%d\n", int(percent*100))
+			date := time.Now().In(loc)
+			if *dateStr != "" {
+				if date, err = time.ParseInLocation("2006-01-02", *dateStr, loc); err != nil {
+					return fmt.Errorf("error parsing date: %w", err)
+				}
+			}
 
-			return nil
+			if *days < 1 || *days > 366 {
+				return fmt.Errorf("days must be between 1 and 366")
+			}
+
+			return writeSunEvents(stdout, root.Output, sunEvents(date, *days, latitude, longitude))
 		},
 	}
 }
+
+// sunEvents returns the solar events of a number of days, starting with the
+// date, in the location of the date.
+func sunEvents(date time.Time, days int, latitude, longitude float64) []sunEventOutput {
+	var (
+		events           []sunEventOutput
+		year, month, day = date.Date()
+	)
+	for i := 0; i < days; i++ {
+		d := time.Date(year, month, day+i, 12, 0, 0, 0, date.Location())
+
+		// The date is that of the event itself, which differs from the day
+		// for e.g. a nadir before midnight.
+		for _, e := range circadian.Events(d, latitude, longitude) {
+			t := e.Time.In(date.Location()).Round(time.Second)
+			events = append(events, sunEventOutput{Date: t.Format("2006-01-02"), Event: e.Name, Time: t})
+		}
+	}
+	return events
+}
+
+//
+//
+//
+
+type sunHeightOutput struct {
+	Time   time.Time `json:"time" yaml:"time"`
+	Height int       `json:"height" yaml:"height"`
+}
+
+func (o sunHeightOutput) csvHeader() []string { return []string{"time", "height"} }
+
+func (o sunHeightOutput) csvRecord() []string {
+	return []string{o.Time.Format(time.RFC3339), fmt.Sprint(o.Height)}
+}
+
+type sunEventOutput struct {
+	Date  string    `json:"date" yaml:"date"`
+	Event string    `json:"event" yaml:"event"`
+	Time  time.Time `json:"time" yaml:"time"`
+}
+
+func (o sunEventOutput) csvHeader() []string { return []string{"date", "event", "time"} }
+
+func (o sunEventOutput) csvRecord() []string {
+	return []string{o.Date, o.Event, o.Time.Format(time.RFC3339)}
+}
+
+func writeSunEvents(w io.Writer, format string, events []sunEventOutput) error {
+	var (
+		vs    = make([]interface{}, len(events))
+		texts = make([]string, len(events))
+	)
+	for i, e := range events {
+		vs[i], texts[i] = e, fmt.Sprintf("%s %-15s %s", e.Date, e.Event, e.Time.Format("15:04:05 MST"))
+	}
+	return writeMany(w, format, vs, texts)
+}
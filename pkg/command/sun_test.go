@@ -0,0 +1,52 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSunEventsDate(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	// In the east of the time zone, the nadir of the night before the 21st is
+	// before midnight.
+	events := sunEvents(time.Date(2026, 12, 21, 0, 0, 0, 0, warsaw), 2, 52.229676, 21.012229)
+	if len(events) == 0 {
+		t.Fatal("no events")
+	}
+
+	for _, e := range events {
+		if want, have := e.Time.Format("2006-01-02"), e.Date; want != have {
+			t.Errorf("%s at %s: want date %s, have %s", e.Event, e.Time, want, have)
+		}
+	}
+
+	if want, have := "2026-12-20", events[0].Date; events[0].Event != "nadir" || want != have {
+		t.Errorf("first event: want nadir on %s, have %s on %s", want, events[0].Event, have)
+	}
+}
+
+func TestSunLocation(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string // error, if any
+	}{
+		{[]string{}, ""}, // Berlin
+		{[]string{"-latitude", "60.169857", "-longitude", "24.938379"}, ""},
+		{[]string{"-latitude", "60.169857"}, "latitude and longitude are required"},
+		{[]string{"events", "-timezone", "UTC"}, "latitude and longitude are required"},
+		{[]string{"events", "-timezone", "UTC", "-longitude", "24.938379"}, "latitude and longitude are required"},
+		{[]string{"events", "-timezone", "UTC", "-latitude", "60.169857", "-longitude", "24.938379"}, ""},
+		{[]string{"events", "-timezone", "UTC", "-latitude", "91", "-longitude", "24.938379"}, "invalid latitude 91"},
+	} {
+		err := Sun(&RootConfig{Output: "text"}, ioutil.Discard, ioutil.Discard).ParseAndRun(context.Background(), tc.args)
+		if want, have := tc.want, errString(err); want != have {
+			t.Errorf("%v: want %q, have %q", tc.args, want, have)
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"gopkg.in/yaml.v2"
+)
+
+const taskLightHelp = `light setting "<device ID or name> [level=0..100] [transition=30m]" (repeatable)`
+
+func Task(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "task",
+		ShortUsage: "lightctl task <subcommand> ...",
+		ShortHelp:  "Interact with smart tasks (wake-up, on/off, not home)",
+		LongHelp:   "Smart tasks are schedules stored and run by the gateway. Times are HH:MM in UTC, as stored by the gateway. The JSON and YAML output of task get can be kept in a file, and passed to task create or update with -file.",
+		Subcommands: []*ffcli.Command{
+			TaskList(root, stdout, stderr),
+			TaskGet(root, stdout, stderr),
+			TaskCreate(root, stdout, stderr),
+			TaskUpdate(root, stdout, stderr),
+			TaskEnable(root, true, stdout, stderr),
+			TaskEnable(root, false, stdout, stderr),
+			TaskDelete(root, stdout, stderr),
+		},
+		Exec: func(ctx context.Context, args []string) error { return flag.ErrHelp },
+	}
+}
+
+func TaskList(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "list",
+		ShortUsage: "lightctl task list",
+		ShortHelp:  "List smart tasks",
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Write whatever tasks could be fetched, and report the rest.
+			tasks, err := client.ListSmartTasks(ctx)
+			var partial *coap.PartialError
+			if err != nil && !errors.As(err, &partial) {
+				return fmt.Errorf("error listing smart tasks: %w", err)
+			}
+
+			if err := writeTasks(stdout, root.Output, tasks); err != nil {
+				return err
+			}
+
+			if partial != nil {
+				return fmt.Errorf("error listing smart tasks: %w", partial)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TaskGet(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl task get", flag.ExitOnError)
+	var (
+		id = fs.Int("id", 0, "smart task ID")
+	)
+
+	return &ffcli.Command{
+		Name:       "get",
+		ShortUsage: "lightctl task get [flags]",
+		ShortHelp:  "Get detailed information about a smart task",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *id == 0 {
+				return fmt.Errorf("smart task ID is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			t, err := client.GetSmartTask(ctx, *id)
+			if err != nil {
+				return fmt.Errorf("error getting smart task %d: %w", *id, err)
+			}
+
+			return writeTask(stdout, root.Output, t)
+		},
+	}
+}
+
+func TaskCreate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl task create", flag.ExitOnError)
+	var tf taskFlags
+	tf.register(fs)
+
+	return &ffcli.Command{
+		Name:       "create",
+		ShortUsage: "lightctl task create [flags]",
+		ShortHelp:  "Create a smart task",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			spec := taskOutput{Enabled: true, Repeat: weekdayNamesOf(coap.Everyday), On: true}
+			if err := tf.apply(ctx, client, &spec); err != nil {
+				return err
+			}
+
+			input, err := spec.input()
+			if err != nil {
+				return err
+			}
+
+			id, err := client.CreateSmartTask(ctx, input)
+			if err != nil {
+				return fmt.Errorf("error creating smart task: %w", err)
+			}
+
+			fmt.Fprintf(stdout, "%d\n", id)
+
+			return nil
+		},
+	}
+}
+
+func TaskUpdate(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl task update", flag.ExitOnError)
+	var (
+		id = fs.Int("id", 0, "smart task ID")
+		tf taskFlags
+	)
+	tf.register(fs)
+
+	return &ffcli.Command{
+		Name:       "update",
+		ShortUsage: "lightctl task update [flags]",
+		ShortHelp:  "Change the schedule or light settings of a smart task",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *id == 0 {
+				return fmt.Errorf("smart task ID is required")
+			}
+			if tf.empty() {
+				return flag.ErrHelp
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			t, err := client.GetSmartTask(ctx, *id)
+			if err != nil {
+				return fmt.Errorf("error getting smart task %d: %w", *id, err)
+			}
+
+			spec := taskOutputFrom(t)
+			if err := tf.apply(ctx, client, &spec); err != nil {
+				return err
+			}
+
+			input, err := spec.input()
+			if err != nil {
+				return err
+			}
+
+			return client.UpdateSmartTask(ctx, *id, input)
+		},
+	}
+}
+
+func TaskEnable(root *RootConfig, enable bool, stdout, stderr io.Writer) *ffcli.Command {
+	name, help := "enable", "Enable a smart task"
+	if !enable {
+		name, help = "disable", "Disable a smart task"
+	}
+
+	fs := flag.NewFlagSet("lightctl task "+name, flag.ExitOnError)
+	var (
+		id = fs.Int("id", 0, "smart task ID")
+	)
+
+	return &ffcli.Command{
+		Name:       name,
+		ShortUsage: "lightctl task " + name + " [flags]",
+		ShortHelp:  help,
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *id == 0 {
+				return fmt.Errorf("smart task ID is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			return client.SetSmartTaskEnabled(ctx, *id, enable)
+		},
+	}
+}
+
+func TaskDelete(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	fs := flag.NewFlagSet("lightctl task delete", flag.ExitOnError)
+	var (
+		id = fs.Int("id", 0, "smart task ID")
+	)
+
+	return &ffcli.Command{
+		Name:       "delete",
+		ShortUsage: "lightctl task delete [flags]",
+		ShortHelp:  "Delete a smart task",
+		FlagSet:    fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if *id == 0 {
+				return fmt.Errorf("smart task ID is required")
+			}
+
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			return client.DeleteSmartTask(ctx, *id)
+		},
+	}
+}
+
+//
+//
+//
+
+// taskFlags are the smart task properties shared by task create and update.
+// Each flag that's set overrides the corresponding property of the task, or of
+// the -file, if given.
+type taskFlags struct {
+	file       string
+	typ        optionalString
+	at         optionalString
+	until      optionalString
+	days       optionalString
+	state      optionalString
+	disabled   optionalBool
+	groupID    int
+	groupName  string
+	level      int
+	transition time.Duration
+	lights     stringSlice
+}
+
+func (tf *taskFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.file, "file", "", "read the task from a JSON or YAML file, as written by task get")
+	fs.Var(&tf.typ, "type", "task type: wake-up, on-off, not-home")
+	fs.Var(&tf.at, "at", "start time, HH:MM in UTC")
+	fs.Var(&tf.until, "until", "end time of a not-home task, HH:MM in UTC")
+	fs.Var(&tf.days, "days", "repeat days: daily, weekdays, weekends, once, or e.g. mon,wed,fri")
+	fs.Var(&tf.state, "state", "state of the lights after an on-off task: on, off")
+	fs.Var(&tf.disabled, "disabled", "disable the task")
+	fs.IntVar(&tf.groupID, "group-id", 0, "set all members of this group")
+	fs.StringVar(&tf.groupName, "group-name", "", "set all members of the group with this name or glob pattern")
+	fs.IntVar(&tf.level, "level", 100, "default level 0..100 of the group members and -light")
+	fs.DurationVar(&tf.transition, "transition", 0, "default transition of the group members and -light, e.g. 30m for a wake-up")
+	fs.Var(&tf.lights, "light", taskLightHelp)
+}
+
+func (tf *taskFlags) empty() bool {
+	return tf.file == "" && !tf.typ.set && !tf.at.set && !tf.until.set && !tf.days.set &&
+		!tf.state.set && !tf.disabled.set && tf.groupID == 0 && tf.groupName == "" && len(tf.lights) == 0
+}
+
+func (tf *taskFlags) apply(ctx context.Context, client *coap.Client, spec *taskOutput) error {
+	if tf.file != "" {
+		buf, err := ioutil.ReadFile(tf.file)
+		if err != nil {
+			return fmt.Errorf("error reading task file: %w", err)
+		}
+		id := spec.ID
+		*spec = taskOutput{}
+		if err := yaml.Unmarshal(buf, spec); err != nil {
+			return fmt.Errorf("error parsing task file: %w", err)
+		}
+		spec.ID = id
+	}
+
+	if tf.typ.set {
+		spec.Type = tf.typ.s
+	}
+	if tf.at.set {
+		spec.Start = tf.at.s
+	}
+	if tf.until.set {
+		spec.End = tf.until.s
+	}
+	if tf.days.set {
+		days, err := parseWeekdays(tf.days.s)
+		if err != nil {
+			return err
+		}
+		spec.Repeat = weekdayNamesOf(days)
+	}
+	if tf.state.set {
+		switch tf.state.s {
+		case "on":
+			spec.On = true
+		case "off":
+			spec.On = false
+		default:
+			return fmt.Errorf("invalid state %q (on, off)", tf.state.s)
+		}
+	}
+	if tf.disabled.set {
+		spec.Enabled = !tf.disabled.b
+	}
+
+	if tf.groupID == 0 && tf.groupName == "" && len(tf.lights) == 0 {
+		return nil
+	}
+
+	// Lights given by flags replace all of the lights of the task.
+	spec.Lights = nil
+	add := func(id int) *taskLightOutput {
+		for i := range spec.Lights {
+			if spec.Lights[i].ID == id {
+				return &spec.Lights[i]
+			}
+		}
+		spec.Lights = append(spec.Lights, taskLightOutput{ID: id, Level: tf.level, Transition: tf.transition.String()})
+		return &spec.Lights[len(spec.Lights)-1]
+	}
+
+	if tf.groupID != 0 || tf.groupName != "" {
+		gid, err := resolveGroup(ctx, client, tf.groupID, tf.groupName)
+		if err != nil {
+			return err
+		}
+		g, err := client.GetGroup(ctx, gid)
+		if err != nil {
+			return fmt.Errorf("error getting group %d: %w", gid, err)
+		}
+		for _, id := range g.GroupMembers.HSLink.IDs {
+			add(id)
+		}
+	}
+
+	for _, s := range tf.lights {
+		// The device name may contain spaces, so it's everything before the
+		// first key=value setting.
+		fields := strings.Fields(s)
+		n := 0
+		for n < len(fields) && !strings.Contains(fields[n], "=") {
+			n++
+		}
+		if n == 0 {
+			return fmt.Errorf("invalid light setting %q", s)
+		}
+
+		id, err := resolveDeviceTarget(ctx, client, strings.Join(fields[:n], " "))
+		if err != nil {
+			return err
+		}
+
+		l := add(id)
+		for _, setting := range fields[n:] {
+			kv := strings.SplitN(setting, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid light setting %q (key=value)", setting)
+			}
+			switch key, val := kv[0], kv[1]; key {
+			case "level":
+				if l.Level, err = strconv.Atoi(val); err != nil {
+					return fmt.Errorf("invalid light setting %q: invalid level %q", s, val)
+				}
+			case "transition":
+				if _, err := time.ParseDuration(val); err != nil {
+					return fmt.Errorf("invalid light setting %q: invalid transition %q", s, val)
+				}
+				l.Transition = val
+			default:
+				return fmt.Errorf("invalid light setting %q: unknown setting %q", s, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// input converts the user-facing representation of a task to a gateway
+// request, validating it along the way.
+func (t taskOutput) input() (coap.SmartTaskInput, error) {
+	var input coap.SmartTaskInput
+
+	typ, ok := taskTypes[t.Type]
+	if !ok {
+		return input, fmt.Errorf("invalid task type %q (wake-up, on-off, not-home)", t.Type)
+	}
+	input.Type = typ
+
+	enabled := coap.YesNo(0)
+	if t.Enabled {
+		enabled = 1
+	}
+	input.Enabled = &enabled
+
+	var days coap.Weekdays
+	for _, name := range t.Repeat {
+		d, err := parseWeekdays(name)
+		if err != nil {
+			return input, err
+		}
+		days |= d
+	}
+	input.RepeatDays = &days
+
+	if t.Start == "" {
+		return input, fmt.Errorf("start time is required")
+	}
+	start, err := time.Parse("15:04", t.Start)
+	if err != nil {
+		return input, fmt.Errorf("invalid start time %q, want HH:MM", t.Start)
+	}
+	trigger := coap.TaskTrigger{StartHour: start.Hour(), StartMinute: start.Minute()}
+	switch {
+	case typ == coap.TaskNotHome && t.End == "":
+		return input, fmt.Errorf("end time is required for not-home tasks")
+	case typ != coap.TaskNotHome && t.End != "":
+		return input, fmt.Errorf("end time is only valid for not-home tasks")
+	case t.End != "":
+		end, err := time.Parse("15:04", t.End)
+		if err != nil {
+			return input, fmt.Errorf("invalid end time %q, want HH:MM", t.End)
+		}
+		hour, minute := end.Hour(), end.Minute()
+		trigger.EndHour, trigger.EndMinute = &hour, &minute
+	}
+	input.Triggers = []coap.TaskTrigger{trigger}
+
+	if len(t.Lights) == 0 {
+		return input, fmt.Errorf("at least one light is required")
+	}
+	action := coap.TaskStartAction{State: 1}
+	if typ == coap.TaskOnOff && !t.On {
+		action.State = 0
+	}
+	for _, l := range t.Lights {
+		var transition time.Duration
+		if l.Transition != "" {
+			if transition, err = time.ParseDuration(l.Transition); err != nil {
+				return input, fmt.Errorf("invalid transition %q of light %d", l.Transition, l.ID)
+			}
+		}
+		action.Lights = append(action.Lights, coap.TaskLight{
+			ID:         l.ID,
+			Dimmer:     coap.Percent255(dimmerFrom(l.Level)),
+			Transition: int(transition / (100 * time.Millisecond)),
+		})
+	}
+	input.StartAction = &action
+
+	return input, nil
+}
+
+var taskTypes = map[string]coap.TaskType{
+	"wake-up":  coap.TaskWakeUp,
+	"on-off":   coap.TaskOnOff,
+	"not-home": coap.TaskNotHome,
+}
+
+func taskTypeName(tt coap.TaskType) string {
+	for name, t := range taskTypes {
+		if t == tt {
+			return name
+		}
+	}
+	return strconv.Itoa(int(tt))
+}
+
+var weekdays = []struct {
+	name string
+	day  coap.Weekdays
+}{
+	{"mon", coap.Monday},
+	{"tue", coap.Tuesday},
+	{"wed", coap.Wednesday},
+	{"thu", coap.Thursday},
+	{"fri", coap.Friday},
+	{"sat", coap.Saturday},
+	{"sun", coap.Sunday},
+}
+
+// parseWeekdays parses a comma-separated list of days, or one of daily,
+// weekdays, weekends, or once.
+func parseWeekdays(s string) (coap.Weekdays, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "daily", "everyday", "every day":
+		return coap.Everyday, nil
+	case "weekdays":
+		return coap.Monday | coap.Tuesday | coap.Wednesday | coap.Thursday | coap.Friday, nil
+	case "weekends":
+		return coap.Saturday | coap.Sunday, nil
+	case "once", "":
+		return 0, nil
+	}
+
+	var days coap.Weekdays
+	for _, field := range strings.Split(s, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		var found bool
+		for _, wd := range weekdays {
+			if len(field) >= 3 && strings.HasPrefix(wd.name, field[:3]) {
+				days, found = days|wd.day, true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid day %q", field)
+		}
+	}
+	return days, nil
+}
+
+func weekdayNamesOf(days coap.Weekdays) []string {
+	names := []string{}
+	for _, wd := range weekdays {
+		if days&wd.day != 0 {
+			names = append(names, wd.name)
+		}
+	}
+	return names
+}
+
+// taskOutput is also the format of task files, see task create -file.
+type taskOutput struct {
+	ID      int               `json:"id" yaml:"id"`
+	Type    string            `json:"type" yaml:"type"`
+	Enabled bool              `json:"enabled" yaml:"enabled"`
+	Repeat  []string          `json:"repeat" yaml:"repeat"`
+	Start   string            `json:"start" yaml:"start"`
+	End     string            `json:"end,omitempty" yaml:"end,omitempty"`
+	On      bool              `json:"on" yaml:"on"`
+	Lights  []taskLightOutput `json:"lights" yaml:"lights"`
+}
+
+type taskLightOutput struct {
+	ID         int    `json:"id" yaml:"id"`
+	Level      int    `json:"level" yaml:"level"`
+	Transition string `json:"transition" yaml:"transition"`
+}
+
+func taskOutputFrom(t coap.SmartTask) taskOutput {
+	var start, end string
+	if len(t.Triggers) > 0 {
+		tr := t.Triggers[0]
+		start = fmt.Sprintf("%02d:%02d", tr.StartHour, tr.StartMinute)
+		if tr.EndHour != nil && tr.EndMinute != nil {
+			end = fmt.Sprintf("%02d:%02d", *tr.EndHour, *tr.EndMinute)
+		}
+	}
+	lights := make([]taskLightOutput, len(t.StartAction.Lights))
+	for i, l := range t.StartAction.Lights {
+		lights[i] = taskLightOutput{
+			ID:         l.ID,
+			Level:      l.Dimmer.Percent(),
+			Transition: (time.Duration(l.Transition) * 100 * time.Millisecond).String(),
+		}
+	}
+	return taskOutput{
+		ID:      t.ID,
+		Type:    taskTypeName(t.Type),
+		Enabled: t.Enabled != 0,
+		Repeat:  weekdayNamesOf(t.RepeatDays),
+		Start:   start,
+		End:     end,
+		On:      t.StartAction.State != 0,
+		Lights:  lights,
+	}
+}
+
+func writeTask(w io.Writer, format string, t coap.SmartTask) error {
+	return writeOne(w, format, taskOutputFrom(t), t.Long())
+}
+
+func writeTasks(w io.Writer, format string, tasks []coap.SmartTask) error {
+	var (
+		vs    = make([]interface{}, len(tasks))
+		texts = make([]string, len(tasks))
+	)
+	for i, t := range tasks {
+		vs[i], texts[i] = taskOutputFrom(t), t.Short()
+	}
+	return writeMany(w, format, vs, texts)
+}
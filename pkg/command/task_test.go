@@ -0,0 +1,93 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestTaskUpdateLights(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New("0123456789abcdef")
+	var (
+		ceiling = g.AddDevice(testLight("Kitchen ceiling"))
+		counter = g.AddDevice(testLight("Kitchen counter"))
+		_       = g.AddDevice(testLight("Hallway"))
+		kitchen = g.AddGroup(coap.Group{Resource: coap.Resource{Name: "Kitchen"}, GroupMembers: coap.NewGroupMembers(ceiling, counter)})
+	)
+	root, done := testGateway(t, g)
+	defer done()
+
+	client, err := root.dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	initial := coap.TaskStartAction{State: 1, Lights: []coap.TaskLight{{ID: ceiling, Dimmer: 254}}}
+	id, err := client.CreateSmartTask(ctx, coap.SmartTaskInput{
+		Type:        coap.TaskWakeUp,
+		StartAction: &initial,
+		Triggers:    []coap.TaskTrigger{{StartHour: 6, StartMinute: 30}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string // JSON of the lights of the task, or the error
+	}{
+		{"ID", []string{"-light", "65537"}, `[{"9003":65537,"5851":255,"5712":0}]`},
+		{"ID with settings", []string{"-light", "65537 level=50 transition=30m"}, `[{"9003":65537,"5851":127,"5712":18000}]`},
+		{"name", []string{"-light", "Kitchen counter level=50"}, `[{"9003":65537,"5851":127,"5712":0}]`},
+		{"name ignoring case", []string{"-light", "kitchen COUNTER"}, `[{"9003":65537,"5851":255,"5712":0}]`},
+		{"glob", []string{"-light", "hall*"}, `[{"9003":65538,"5851":255,"5712":0}]`},
+		{"defaults", []string{"-level", "10", "-transition", "1m", "-light", "Hallway", "-light", "65536 level=50"}, `[{"9003":65538,"5851":25,"5712":600},{"9003":65536,"5851":127,"5712":600}]`},
+		{"group", []string{"-group-name", "kitchen", "-level", "50"}, `[{"9003":65536,"5851":127,"5712":0},{"9003":65537,"5851":127,"5712":0}]`},
+		{"group and light", []string{"-group-id", strconv.Itoa(kitchen), "-light", "Kitchen counter level=10", "-light", "Hallway"}, `[{"9003":65536,"5851":255,"5712":0},{"9003":65537,"5851":25,"5712":0},{"9003":65538,"5851":255,"5712":0}]`},
+		{"ambiguous glob", []string{"-light", "Kitchen*"}, `name "Kitchen*" is ambiguous: matches devices 65536 (Kitchen ceiling), 65537 (Kitchen counter)`},
+		{"unknown name", []string{"-light", "Porch"}, `no device matches name "Porch"`},
+		{"unknown group", []string{"-group-name", "Porch"}, `no group matches name "Porch"`},
+		{"no target", []string{"-light", "level=50"}, `invalid light setting "level=50"`},
+		{"no value", []string{"-light", "65536 level=50 foo"}, `invalid light setting "foo" (key=value)`},
+		{"setting without a value", []string{"-light", "65536 transition"}, `no device matches name "65536 transition"`},
+		{"invalid level", []string{"-light", "65536 level=high"}, `invalid light setting "65536 level=high": invalid level "high"`},
+		{"invalid transition", []string{"-light", "65536 transition=soon"}, `invalid light setting "65536 transition=soon": invalid transition "soon"`},
+		{"unknown setting", []string{"-light", "65536 color=red"}, `invalid light setting "65536 color=red": unknown setting "color"`},
+	} {
+		// Each case starts from the initial lights, which an error must keep.
+		if err := client.UpdateSmartTask(ctx, id, coap.SmartTaskInput{StartAction: &initial}); err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"-id", strconv.Itoa(id)}, tc.args...)
+		err := TaskUpdate(root, ioutil.Discard, ioutil.Discard).ParseAndRun(ctx, args)
+
+		task, terr := client.GetSmartTask(ctx, id)
+		if terr != nil {
+			t.Fatal(terr)
+		}
+		buf, _ := json.Marshal(task.StartAction.Lights)
+
+		have := string(buf)
+		if err != nil {
+			have = err.Error()
+			if len(task.StartAction.Lights) != 1 || task.StartAction.Lights[0] != initial.Lights[0] {
+				t.Errorf("%s: task changed after an error: %s", tc.name, buf)
+			}
+		}
+		if tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+	}
+}
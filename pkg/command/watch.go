@@ -0,0 +1,177 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/peterbourgon/ff/v2/ffcli"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func Watch(root *RootConfig, stdout, stderr io.Writer) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "watch",
+		ShortUsage: "lightctl watch",
+		ShortHelp:  "Stream state changes of all devices and groups",
+		Exec: func(ctx context.Context, args []string) error {
+			client, err := root.dial(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Watch whatever could be fetched, rather than nothing at all.
+			var partial *coap.PartialError
+			devices, err := client.ListDevices(ctx)
+			switch {
+			case errors.As(err, &partial):
+				fmt.Fprintf(stderr, "warning: %v\n", partial)
+			case err != nil:
+				return fmt.Errorf("error listing devices: %w", err)
+			}
+
+			groups, err := client.ListGroups(ctx)
+			switch {
+			case errors.As(err, &partial):
+				fmt.Fprintf(stderr, "warning: %v\n", partial)
+			case err != nil:
+				return fmt.Errorf("error listing groups: %w", err)
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			// Each stream sends a final event on ended, when its observation
+			// ends, e.g. because the device was removed.
+			var (
+				events = make(chan string)
+				ended  = make(chan string)
+			)
+
+			for _, d := range devices {
+				updates, err := client.ObserveDevice(ctx, d.ID)
+				if err != nil {
+					return fmt.Errorf("error observing device %d: %w", d.ID, err)
+				}
+				go func(prev coap.Device) {
+					for next := range updates {
+						for _, ch := range deviceChanges(prev, next) {
+							select {
+							case events <- fmt.Sprintf("device %d %s: %s", next.ID, next.Name, ch):
+							case <-ctx.Done():
+								return
+							}
+						}
+						prev = next
+					}
+					select {
+					case ended <- fmt.Sprintf("device %d %s: observation ended", prev.ID, prev.Name):
+					case <-ctx.Done():
+					}
+				}(d)
+			}
+
+			for _, g := range groups {
+				updates, err := client.ObserveGroup(ctx, g.ID)
+				if err != nil {
+					return fmt.Errorf("error observing group %d: %w", g.ID, err)
+				}
+				go func(prev coap.Group) {
+					for next := range updates {
+						for _, ch := range groupChanges(prev, next) {
+							select {
+							case events <- fmt.Sprintf("group %d %s: %s", next.ID, next.Name, ch):
+							case <-ctx.Done():
+								return
+							}
+						}
+						prev = next
+					}
+					select {
+					case ended <- fmt.Sprintf("group %d %s: observation ended", prev.ID, prev.Name):
+					case <-ctx.Done():
+					}
+				}(g)
+			}
+
+			fmt.Fprintf(stderr, "watching %d device%s and %d group%s\n", len(devices), plural(len(devices)), len(groups), plural(len(groups)))
+
+			for live := len(devices) + len(groups); live > 0; {
+				select {
+				case e := <-events:
+					fmt.Fprintf(stdout, "%s %s\n", time.Now().Format(time.RFC3339), e)
+				case e := <-ended:
+					fmt.Fprintf(stdout, "%s %s\n", time.Now().Format(time.RFC3339), e)
+					live--
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			return errors.New("all observations ended")
+		},
+	}
+}
+
+type change struct {
+	field    string
+	old, new string
+}
+
+func (c change) String() string {
+	return fmt.Sprintf("%s %s -> %s", c.field, c.old, c.new)
+}
+
+func deviceChanges(prev, next coap.Device) []change {
+	var cs changes
+	cs.add("name", prev.Name, next.Name)
+	cs.add("reachable", prev.Reachable.String(), next.Reachable.String())
+	cs.add("firmware", prev.DeviceInfo.Firmware, next.DeviceInfo.Firmware)
+	cs.add("battery", prev.DeviceInfo.BatteryLevel.String(), next.DeviceInfo.BatteryLevel.String())
+	for i := 0; i < len(prev.LightControl) || i < len(next.LightControl); i++ {
+		var p, n coap.LightControl
+		if i < len(prev.LightControl) {
+			p = prev.LightControl[i]
+		}
+		if i < len(next.LightControl) {
+			n = next.LightControl[i]
+		}
+		prefix := "light "
+		if len(prev.LightControl) > 1 || len(next.LightControl) > 1 {
+			prefix = fmt.Sprintf("light %d ", i+1)
+		}
+		cs.add(prefix+"state", p.State.String(), n.State.String())
+		cs.add(prefix+"dimmer", p.Dimmer.String(), n.Dimmer.String())
+		cs.add(prefix+"color", p.LightColorHex, n.LightColorHex)
+		cs.add(prefix+"mireds", strconv.Itoa(p.LightMireds), strconv.Itoa(n.LightMireds))
+	}
+	return cs
+}
+
+func groupChanges(prev, next coap.Group) []change {
+	var cs changes
+	cs.add("name", prev.Name, next.Name)
+	cs.add("state", prev.State.String(), next.State.String())
+	cs.add("dimmer", prev.Dimmer.String(), next.Dimmer.String())
+	cs.add("color", prev.LightColorHex, next.LightColorHex)
+	cs.add("mood", strconv.Itoa(prev.MoodID), strconv.Itoa(next.MoodID))
+	cs.add("members", fmt.Sprint(prev.GroupMembers.HSLink.IDs), fmt.Sprint(next.GroupMembers.HSLink.IDs))
+	return cs
+}
+
+type changes []change
+
+func (cs *changes) add(field, old, new string) {
+	if old != new {
+		*cs = append(*cs, change{field: field, old: old, new: new})
+	}
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,62 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/fakegateway"
+)
+
+func TestWatchEnds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := fakegateway.New("0123456789abcdef")
+	id := g.AddDevice(testLight("Kitchen ceiling"))
+	root, done := testGateway(t, g)
+	defer done()
+
+	stderr, stderrw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Watch(root, ioutil.Discard, stderrw).Exec(ctx, nil)
+		stderrw.Close()
+	}()
+
+	// Wait until the device is watched.
+	s := bufio.NewScanner(stderr)
+	if !s.Scan() || !strings.HasPrefix(s.Text(), "watching 1 device") {
+		t.Fatalf("want watching, have %q", s.Text())
+	}
+	go io.Copy(ioutil.Discard, stderr)
+
+	client, err := root.dial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.RemoveDevice(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if want, have := "all observations ended", errString(err); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	case <-ctx.Done():
+		t.Fatal("watch didn't return after its only observation ended")
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -2,9 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 // FilePath is the location of the config file.
@@ -18,7 +20,29 @@ var FilePath = func() string {
 	panic("unable to deduce user config dir or user home dir")
 }()
 
+// DefaultProfile is the name of the profile created by the first auth, if no
+// profile name is given.
+const DefaultProfile = "default"
+
+// LegacyGateway is the default gateway address of older versions, which
+// didn't store an address with the credentials.
+const LegacyGateway = "udp://10.0.1.11:5684"
+
+// Config holds the credentials for one or more gateways, as named profiles.
 type Config struct {
+	Default  string             `json:"default,omitempty"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	// Username and PSK are the single set of credentials of older config
+	// files. Load converts them to a profile named DefaultProfile, with the
+	// LegacyGateway address.
+	Username string `json:"username,omitempty"`
+	PSK      string `json:"psk,omitempty"`
+}
+
+// Profile is a gateway, and the credentials for it.
+type Profile struct {
+	Gateway  string `json:"gateway,omitempty"` // e.g. udp://10.0.1.11:5684
 	Username string `json:"username"`
 	PSK      string `json:"psk"`
 }
@@ -33,5 +57,73 @@ func Load() (c Config, err error) {
 		return c, err
 	}
 
+	if c.Username != "" || c.PSK != "" {
+		c.Set(DefaultProfile, Profile{Gateway: LegacyGateway, Username: c.Username, PSK: c.PSK})
+		c.Username, c.PSK = "", ""
+	}
+
 	return c, nil
 }
+
+// Save writes the config to FilePath, creating the directory if necessary.
+func Save(c Config) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(FilePath), 0700); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+
+	if err := ioutil.WriteFile(FilePath, buf, 0600); err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+
+	return nil
+}
+
+// Profile returns the named profile, or the default profile if the name is
+// empty. If there's no default, but only a single profile, that's used.
+func (c Config) Profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" && len(c.Profiles) == 1 {
+		for only := range c.Profiles {
+			name = only
+		}
+	}
+	if name == "" {
+		return "", Profile{}, fmt.Errorf("no default profile, choose one of %v with -profile", c.Names())
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", Profile{}, fmt.Errorf("profile %q not found", name)
+	}
+
+	return name, p, nil
+}
+
+// Set adds or replaces the named profile. The first profile becomes the
+// default.
+func (c *Config) Set(name string, p Profile) {
+	if c.Profiles == nil {
+		c.Profiles = map[string]Profile{}
+	}
+	c.Profiles[name] = p
+	if c.Default == "" {
+		c.Default = name
+	}
+}
+
+// Names returns the names of all profiles, in order.
+func (c Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { FilePath = path }(FilePath)
+	FilePath = filepath.Join(dir, "lightctl.conf")
+
+	if err := ioutil.WriteFile(FilePath, []byte(`{"username":"me","psk":"secret"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, p, err := c.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := DefaultProfile, name; want != have {
+		t.Errorf("profile name: want %q, have %q", want, have)
+	}
+	if want, have := (Profile{Gateway: "udp://10.0.1.11:5684", Username: "me", PSK: "secret"}), p; want != have {
+		t.Errorf("profile: want %+v, have %+v", want, have)
+	}
+	if c.Username != "" || c.PSK != "" {
+		t.Errorf("legacy credentials weren't cleared: %+v", c)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	for _, tc := range []struct {
+		config Config
+		name   string
+		want   string // empty for an error
+	}{
+		{Config{}, "", ""},
+		{Config{Profiles: map[string]Profile{"a": {}}}, "", "a"},
+		{Config{Profiles: map[string]Profile{"a": {}, "b": {}}}, "", ""},
+		{Config{Default: "b", Profiles: map[string]Profile{"a": {}, "b": {}}}, "", "b"},
+		{Config{Default: "b", Profiles: map[string]Profile{"a": {}, "b": {}}}, "a", "a"},
+		{Config{Default: "b", Profiles: map[string]Profile{"a": {}, "b": {}}}, "c", ""},
+	} {
+		have, _, err := tc.config.Profile(tc.name)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%+v %q: want error, have %q", tc.config, tc.name, have)
+		case tc.want != "" && err != nil:
+			t.Errorf("%+v %q: %v", tc.config, tc.name, err)
+		case tc.want != have:
+			t.Errorf("%+v %q: want %q, have %q", tc.config, tc.name, tc.want, have)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package discovery finds TRÅDFRI gateways on the local network via mDNS.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Service is the DNS-SD service type advertised by the gateway.
+const Service = "_coap._udp.local."
+
+// DefaultTimeout is long enough for every gateway on a typical home network to
+// answer, including the ones that miss the first query.
+const DefaultTimeout = 3 * time.Second
+
+// queryInterval is how often the query is repeated while browsing, as
+// multicast packets are easily lost.
+const queryInterval = time.Second
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Gateway is a TRÅDFRI gateway found on the network.
+type Gateway struct {
+	Name string // instance name, e.g. gw-b072bf257a41
+	Host string // e.g. TRADFRI-Gateway-b072bf257a41.local.
+	IP   net.IP
+	Port int
+}
+
+// Address returns the gateway address in the form taken by the -gateway flag,
+// e.g. udp://10.0.1.11:5684.
+func (g Gateway) Address() string {
+	return "udp://" + net.JoinHostPort(g.IP.String(), strconv.Itoa(g.Port))
+}
+
+// Browse queries the local network for TRÅDFRI gateways until the context is
+// done, and returns the gateways that answered, ordered by name. Instances of
+// Service that don't look like a TRÅDFRI gateway, i.e. whose name doesn't
+// start with "gw-", are ignored.
+func Browse(ctx context.Context) ([]Gateway, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket: %w", err)
+	}
+	defer conn.Close()
+
+	// Queries are sent from an ephemeral port, so responders answer directly
+	// to it with unicast, per RFC 6762 section 6.7.
+	b := newBrowser()
+	go func() {
+		t := time.NewTicker(queryInterval)
+		defer t.Stop()
+		for {
+			if query, err := b.query(); err == nil {
+				conn.WriteTo(query, mdnsAddr)
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				conn.SetReadDeadline(time.Now()) // interrupt ReadFrom
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				continue
+			}
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		b.handle(buf[:n]) // malformed and unrelated messages are ignored
+	}
+
+	return b.gateways(), nil
+}
+
+// browser collects the records from mDNS responses. The records for one
+// gateway may be spread over several responses, in any order.
+type browser struct {
+	mtx       sync.Mutex
+	instances map[string]*instance
+	hosts     map[string]net.IP
+}
+
+type instance struct {
+	host string // as given, lower case for lookups in hosts
+	port int
+}
+
+func newBrowser() *browser {
+	return &browser{
+		instances: map[string]*instance{},
+		hosts:     map[string]net.IP{},
+	}
+}
+
+// query builds a PTR query for Service, along with SRV and A queries for any
+// records that are still missing from the responses so far.
+func (b *browser) query() ([]byte, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	questions := []dnsmessage.Question{question(Service, dnsmessage.TypePTR)}
+	for name, inst := range b.instances {
+		switch {
+		case inst.host == "":
+			questions = append(questions, question(name, dnsmessage.TypeSRV))
+		case b.hosts[strings.ToLower(inst.host)] == nil:
+			questions = append(questions, question(inst.host, dnsmessage.TypeA))
+		}
+	}
+
+	msg := dnsmessage.Message{Questions: questions}
+	return msg.Pack()
+}
+
+func question(name string, typ dnsmessage.Type) dnsmessage.Question {
+	return dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  typ,
+		Class: dnsmessage.ClassINET,
+	}
+}
+
+func (b *browser) handle(packet []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Header.Response {
+		return
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	// DNS names are case-insensitive, so they're looked up in lower case.
+	var records []dnsmessage.Resource
+	records = append(records, msg.Answers...)
+	records = append(records, msg.Authorities...)
+	records = append(records, msg.Additionals...)
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			target := strings.ToLower(body.PTR.String())
+			if _, ok := b.instances[target]; !ok && name == Service && isGateway(target) {
+				b.instances[target] = &instance{}
+			}
+
+		case *dnsmessage.SRVResource:
+			if !isGateway(name) {
+				continue
+			}
+			b.instances[name] = &instance{
+				host: body.Target.String(),
+				port: int(body.Port),
+			}
+
+		case *dnsmessage.AResource:
+			b.hosts[name] = net.IP(body.A[:])
+		}
+	}
+}
+
+// gateways returns the instances for which the host address is known.
+func (b *browser) gateways() []Gateway {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var gateways []Gateway
+	for name, inst := range b.instances {
+		ip := b.hosts[strings.ToLower(inst.host)]
+		if ip == nil {
+			continue
+		}
+		gateways = append(gateways, Gateway{
+			Name: instanceName(name),
+			Host: inst.host,
+			IP:   ip,
+			Port: inst.port,
+		})
+	}
+
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].Name < gateways[j].Name })
+	return gateways
+}
+
+// isGateway reports whether the full, lower case instance name, e.g.
+// gw-b072bf257a41._coap._udp.local., belongs to a TRÅDFRI gateway.
+func isGateway(name string) bool {
+	return strings.HasPrefix(name, "gw-") && strings.HasSuffix(name, "."+Service)
+}
+
+func instanceName(name string) string {
+	return name[:len(name)-len("."+Service)]
+}
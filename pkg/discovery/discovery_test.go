@@ -0,0 +1,179 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	testInstance = "gw-b072bf257a41._coap._udp.local."
+	testHost     = "TRADFRI-Gateway-b072bf257a41.local."
+)
+
+func TestBrowserGateways(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		packets [][]byte
+		want    string
+	}{
+		{
+			name:    "one packet",
+			packets: [][]byte{response(t, ptr(Service, testInstance), srv(testInstance, testHost, 5684), a(testHost, "10.0.1.11"))},
+			want:    "gw-b072bf257a41 TRADFRI-Gateway-b072bf257a41.local. udp://10.0.1.11:5684",
+		},
+		{
+			name: "split packets in any order",
+			packets: [][]byte{
+				response(t, a(testHost, "10.0.1.11")),
+				response(t, srv(testInstance, testHost, 5684)),
+				response(t, ptr(Service, testInstance)),
+			},
+			want: "gw-b072bf257a41 TRADFRI-Gateway-b072bf257a41.local. udp://10.0.1.11:5684",
+		},
+		{
+			name: "names in any case",
+			packets: [][]byte{
+				response(t, ptr("_COAP._udp.local.", "GW-B072BF257A41._coap._udp.local.")),
+				response(t, srv(testInstance, testHost, 5684), a(strings.ToLower(testHost), "10.0.1.11")),
+			},
+			want: "gw-b072bf257a41 TRADFRI-Gateway-b072bf257a41.local. udp://10.0.1.11:5684",
+		},
+		{
+			name: "two gateways by name",
+			packets: [][]byte{
+				response(t, srv("gw-b._coap._udp.local.", "b.local.", 5684), a("b.local.", "10.0.1.12")),
+				response(t, srv("gw-a._coap._udp.local.", "a.local.", 5684), a("a.local.", "10.0.1.11")),
+			},
+			want: "gw-a a.local. udp://10.0.1.11:5684, gw-b b.local. udp://10.0.1.12:5684",
+		},
+		{
+			name:    "other service",
+			packets: [][]byte{response(t, ptr(Service, "printer._coap._udp.local."), srv("printer._coap._udp.local.", "printer.local.", 5683), a("printer.local.", "10.0.1.20"))},
+			want:    "",
+		},
+		{
+			name:    "gateway name for another service",
+			packets: [][]byte{response(t, ptr("_http._tcp.local.", "gw-b072bf257a41._http._tcp.local."), srv("gw-b072bf257a41._http._tcp.local.", testHost, 80), a(testHost, "10.0.1.11"))},
+			want:    "",
+		},
+		{
+			name:    "missing address",
+			packets: [][]byte{response(t, ptr(Service, testInstance), srv(testInstance, testHost, 5684))},
+			want:    "",
+		},
+		{
+			name:    "query",
+			packets: [][]byte{message(t, false, srv(testInstance, testHost, 5684), a(testHost, "10.0.1.11"))},
+			want:    "",
+		},
+		{
+			name:    "malformed",
+			packets: [][]byte{[]byte("not a DNS message")},
+			want:    "",
+		},
+	} {
+		b := newBrowser()
+		for _, packet := range tc.packets {
+			b.handle(packet)
+		}
+		if want, have := tc.want, formatGateways(b.gateways()); want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, want, have)
+		}
+	}
+}
+
+func TestBrowserQuery(t *testing.T) {
+	b := newBrowser()
+	for _, tc := range []struct {
+		name   string
+		packet []byte
+		want   string
+	}{
+		{"initial", nil, "TypePTR _coap._udp.local."},
+		{"after PTR", response(t, ptr(Service, testInstance)), "TypePTR _coap._udp.local., TypeSRV gw-b072bf257a41._coap._udp.local."},
+		{"after SRV", response(t, srv(testInstance, testHost, 5684)), "TypePTR _coap._udp.local., TypeA TRADFRI-Gateway-b072bf257a41.local."},
+		{"after A", response(t, a(testHost, "10.0.1.11")), "TypePTR _coap._udp.local."},
+	} {
+		if tc.packet != nil {
+			b.handle(tc.packet)
+		}
+
+		query, err := b.query()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		questions := make([]string, len(msg.Questions))
+		for i, q := range msg.Questions {
+			questions[i] = fmt.Sprintf("%s %s", q.Type, q.Name)
+		}
+		if want, have := tc.want, strings.Join(questions, ", "); want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, want, have)
+		}
+	}
+}
+
+type record func(b *dnsmessage.Builder) error
+
+func ptr(name, target string) record {
+	return func(b *dnsmessage.Builder) error {
+		return b.PTRResource(header(name), dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)})
+	}
+}
+
+func srv(name, target string, port uint16) record {
+	return func(b *dnsmessage.Builder) error {
+		return b.SRVResource(header(name), dnsmessage.SRVResource{Target: dnsmessage.MustNewName(target), Port: port})
+	}
+}
+
+func a(name, ip string) record {
+	return func(b *dnsmessage.Builder) error {
+		var r dnsmessage.AResource
+		copy(r.A[:], net.ParseIP(ip).To4())
+		return b.AResource(header(name), r)
+	}
+}
+
+func header(name string) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 120}
+}
+
+func response(t *testing.T, records ...record) []byte {
+	t.Helper()
+	return message(t, true, records...)
+}
+
+func message(t *testing.T, response bool, records ...record) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: response, Authoritative: response})
+	b.EnableCompression()
+	if err := b.StartAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := r(&b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func formatGateways(gateways []Gateway) string {
+	s := make([]string, len(gateways))
+	for i, g := range gateways {
+		s[i] = fmt.Sprintf("%s %s %s", g.Name, g.Host, g.Address())
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,1052 @@
+// Package fakegateway implements an in-memory TRÅDFRI gateway. It serves the
+// same CoAP-over-DTLS-PSK resource tree as the real thing, and is meant for
+// tests and offline development.
+package fakegateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gocoap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/go-coap/codes"
+	coapnet "github.com/go-ocf/go-coap/net"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+	"github.com/pion/dtls/v2"
+)
+
+// AuthIdentity is the PSK identity used, together with the security code, to
+// provision new identities via /15011/9063.
+const AuthIdentity = "Client_identity"
+
+// Firmware is the firmware version reported by the gateway.
+const Firmware = "1.10.36"
+
+const (
+	firstDeviceID = 65536
+	firstGroupID  = 131073
+	firstMoodID   = 196608
+	firstTaskID   = 262144
+)
+
+// Gateway is an in-memory TRÅDFRI gateway.
+type Gateway struct {
+	code string
+
+	mtx         sync.Mutex
+	identities  map[string]string // username: PSK
+	devices     map[int]*coap.Device
+	groups      map[int]*coap.Group
+	moods       map[int]map[int]*coap.Mood // group ID: mood ID: mood
+	tasks       map[int]*coap.SmartTask
+	nextDevice  int
+	nextGroup   int
+	nextMood    int
+	nextTask    int
+	ntpServer   string
+	started     time.Time
+	reboots     int
+	unavailable bool
+	failing     map[int]bool                                  // resource ID
+	observers   map[resource]map[string]gocoap.ResponseWriter // token: writer
+	sequence    uint32
+
+	ln        *packetListener
+	sessions  []session
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns an empty gateway with the given security code, i.e. the PSK for
+// the AuthIdentity.
+func New(securityCode string) *Gateway {
+	return &Gateway{
+		code:       securityCode,
+		identities: map[string]string{},
+		devices:    map[int]*coap.Device{},
+		groups:     map[int]*coap.Group{},
+		moods:      map[int]map[int]*coap.Mood{},
+		tasks:      map[int]*coap.SmartTask{},
+		nextDevice: firstDeviceID,
+		nextGroup:  firstGroupID,
+		nextMood:   firstMoodID,
+		nextTask:   firstTaskID,
+		ntpServer:  "pool.ntp.org",
+		started:    time.Now(),
+		failing:    map[int]bool{},
+		observers:  map[resource]map[string]gocoap.ResponseWriter{},
+		done:       make(chan struct{}),
+	}
+}
+
+// AddIdentity provisions a username and PSK, as if it had been created by a
+// successful auth request.
+func (g *Gateway) AddIdentity(username, psk string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.identities[username] = psk
+}
+
+// AddDevice adds a device to the gateway. If the device ID is zero, a new ID
+// is assigned. The ID of the device is returned.
+func (g *Gateway) AddDevice(d coap.Device) int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if d.ID == 0 {
+		d.ID = g.nextDevice
+	}
+	if d.ID >= g.nextDevice {
+		g.nextDevice = d.ID + 1
+	}
+	if d.CreatedAt == 0 {
+		d.CreatedAt = coap.Timestamp(time.Now().Unix())
+	}
+	if d.LastSeen == 0 {
+		d.LastSeen = coap.Timestamp(time.Now().Unix())
+	}
+	g.devices[d.ID] = &d
+	return d.ID
+}
+
+// AddGroup adds a group to the gateway. If the group ID is zero, a new ID is
+// assigned. The ID of the group is returned.
+func (g *Gateway) AddGroup(gr coap.Group) int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if gr.ID == 0 {
+		gr.ID = g.nextGroup
+	}
+	if gr.ID >= g.nextGroup {
+		g.nextGroup = gr.ID + 1
+	}
+	if gr.CreatedAt == 0 {
+		gr.CreatedAt = coap.Timestamp(time.Now().Unix())
+	}
+	g.groups[gr.ID] = &gr
+	return gr.ID
+}
+
+// AddMood adds a mood to an existing group. If the mood ID is zero, a new ID is
+// assigned. The ID of the mood is returned.
+func (g *Gateway) AddMood(groupID int, m coap.Mood) (int, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if _, ok := g.groups[groupID]; !ok {
+		return 0, fmt.Errorf("group %d not found", groupID)
+	}
+	return g.addMood(groupID, m), nil
+}
+
+func (g *Gateway) addMood(groupID int, m coap.Mood) int {
+	if m.ID == 0 {
+		m.ID = g.nextMood
+	}
+	if m.ID >= g.nextMood {
+		g.nextMood = m.ID + 1
+	}
+	if m.CreatedAt == 0 {
+		m.CreatedAt = coap.Timestamp(time.Now().Unix())
+	}
+	if g.moods[groupID] == nil {
+		g.moods[groupID] = map[int]*coap.Mood{}
+	}
+	g.moods[groupID][m.ID] = &m
+	return m.ID
+}
+
+// Device returns the current state of a device.
+func (g *Gateway) Device(id int) (coap.Device, bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	d, ok := g.devices[id]
+	if !ok {
+		return coap.Device{}, false
+	}
+	return *d, true
+}
+
+// Group returns the current state of a group.
+func (g *Gateway) Group(id int) (coap.Group, bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	gr, ok := g.groups[id]
+	if !ok {
+		return coap.Group{}, false
+	}
+	return *gr, true
+}
+
+// UpdateDevice replaces the state of an existing device, and notifies
+// observers, as if the device had been changed by another client.
+func (g *Gateway) UpdateDevice(d coap.Device) error {
+	g.mtx.Lock()
+	if _, ok := g.devices[d.ID]; !ok {
+		g.mtx.Unlock()
+		return fmt.Errorf("device %d not found", d.ID)
+	}
+	g.devices[d.ID] = &d
+	g.mtx.Unlock()
+
+	g.notify(resource{root: coap.RootDevices, id: d.ID})
+	return nil
+}
+
+// Reboots returns the number of reboot requests the gateway has received.
+func (g *Gateway) Reboots() int {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.reboots
+}
+
+// SetUnavailable makes the gateway respond to every request with 5.03 Service
+// Unavailable, as the real gateway does when it's overloaded.
+func (g *Gateway) SetUnavailable(unavailable bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.unavailable = unavailable
+}
+
+// SetFailing makes the gateway respond to requests for the resource with the
+// given ID with 5.03 Service Unavailable, while it's still listed, so that
+// listing its kind of resource partially fails.
+func (g *Gateway) SetFailing(id int, failing bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.failing[id] = failing
+}
+
+// Listen starts serving on the given UDP address, e.g. "127.0.0.1:0".
+func (g *Gateway) Listen(address string) error {
+	ln, err := listenPacket(address)
+	if err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+
+	g.ln = ln
+	go g.serve()
+	return nil
+}
+
+// Addr returns the address the gateway is listening on.
+func (g *Gateway) Addr() net.Addr {
+	return g.ln.Addr()
+}
+
+// URL returns the gateway address in the form expected by lightctl.
+func (g *Gateway) URL() string {
+	return "udp://" + g.Addr().String()
+}
+
+// Close stops the gateway and terminates all sessions.
+func (g *Gateway) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.done)
+		err = g.ln.Close()
+
+		g.DropSessions()
+	})
+	return err
+}
+
+// DropSessions terminates all sessions, and forgets all observers, as the
+// real gateway regularly does. Clients must establish a new session.
+func (g *Gateway) DropSessions() {
+	g.mtx.Lock()
+	sessions := g.sessions
+	g.sessions = nil
+	g.observers = map[resource]map[string]gocoap.ResponseWriter{}
+	g.mtx.Unlock()
+
+	// Shutdown waits for in-flight requests, which take the lock.
+	for _, s := range sessions {
+		s.conn.Close()
+		s.srv.Shutdown()
+	}
+}
+
+type session struct {
+	conn net.Conn
+	srv  *gocoap.Server
+}
+
+// psk returns the PSK for a client identity.
+func (g *Gateway) psk(identity string) ([]byte, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if identity == AuthIdentity {
+		return []byte(g.code), nil
+	}
+	psk, ok := g.identities[identity]
+	if !ok {
+		return nil, fmt.Errorf("unknown identity %q", identity)
+	}
+	return []byte(psk), nil
+}
+
+func (g *Gateway) serve() {
+	for {
+		conn, err := g.ln.Accept()
+		if err != nil {
+			return
+		}
+		go g.handshake(conn)
+	}
+}
+
+// handshake establishes a DTLS session over a new connection, and serves it.
+// Packets from a dropped session look like a new connection, so handshakes
+// time out quickly.
+func (g *Gateway) handshake(conn net.Conn) {
+	var identity string
+	dconn, err := dtls.Server(conn, &dtls.Config{
+		PSK: func(hint []byte) ([]byte, error) {
+			identity = string(hint)
+			return g.psk(identity)
+		},
+		ConnectTimeout: dtls.ConnectTimeoutOption(time.Second),
+		CipherSuites: []dtls.CipherSuiteID{
+			dtls.TLS_PSK_WITH_AES_128_CCM,
+			dtls.TLS_PSK_WITH_AES_128_CCM_8,
+			dtls.TLS_PSK_WITH_AES_128_GCM_SHA256,
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	srv := &gocoap.Server{
+		Conn: coapnet.NewConnDTLS(dconn),
+		Handler: gocoap.HandlerFunc(func(w gocoap.ResponseWriter, r *gocoap.Request) {
+			g.handle(identity, w, r)
+		}),
+	}
+
+	g.mtx.Lock()
+	select {
+	case <-g.done:
+		g.mtx.Unlock()
+		dconn.Close()
+		return
+	default:
+	}
+	g.sessions = append(g.sessions, session{conn: dconn, srv: srv})
+	g.mtx.Unlock()
+
+	srv.ActivateAndServe()
+}
+
+//
+//
+//
+
+var (
+	errNotFound         = errors.New("not found")
+	errBadRequest       = errors.New("bad request")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errUnauthorized     = errors.New("unauthorized")
+)
+
+func (g *Gateway) handle(identity string, w gocoap.ResponseWriter, r *gocoap.Request) {
+	var (
+		method  = r.Msg.Code()
+		payload = r.Msg.Payload()
+		res, ok = parseResource(r.Msg.Path())
+		code    codes.Code
+		body    interface{}
+		changed []resource
+		err     error
+	)
+
+	g.mtx.Lock()
+	unavailable := g.unavailable || (ok && g.failing[res.id])
+	g.mtx.Unlock()
+	if unavailable {
+		write(w, codes.ServiceUnavailable, nil, nil)
+		return
+	}
+
+	switch {
+	case r.Msg.PathString() == "15011/9063":
+		code, body, err = g.auth(identity, method, payload)
+	case identity == AuthIdentity:
+		err = errUnauthorized
+	case r.Msg.PathString() == "15011/15012":
+		code, body, err = g.gatewayInfo(method, payload)
+	case r.Msg.PathString() == "15011/9030":
+		code, body, err = g.reboot(method)
+	case r.Msg.PathString() == "15011/9034":
+		code, body, err = g.checkFirmware(method)
+	case !ok:
+		err = errNotFound
+	case res.root == coap.RootDevices && res.id == 0:
+		code, body, err = g.listDevices(method)
+	case res.root == coap.RootDevices:
+		code, body, changed, err = g.device(method, res.id, payload)
+	case res.root == coap.RootGroups && res.id == 0 && method == codes.POST:
+		code, body, err = g.createGroup(payload)
+	case res.root == coap.RootGroups && res.id == 0:
+		code, body, err = g.listGroups(method)
+	case res.root == coap.RootGroups:
+		code, body, changed, err = g.group(method, res.id, payload)
+	case res.root == coap.RootMoods && res.id == 0:
+		code, body, err = g.listGroups(method)
+	case res.root == coap.RootMoods && res.sub == 0:
+		code, body, err = g.groupMoods(method, res.id, payload)
+	case res.root == coap.RootMoods:
+		code, body, err = g.mood(method, res.id, res.sub, payload)
+	case res.root == coap.RootSmartTasks && res.id == 0:
+		code, body, err = g.listTasks(method, payload)
+	case res.root == coap.RootSmartTasks:
+		code, body, err = g.task(method, res.id, payload)
+	default:
+		err = errNotFound
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotFound):
+		code = codes.NotFound
+	case errors.Is(err, errBadRequest):
+		code = codes.BadRequest
+	case errors.Is(err, errMethodNotAllowed):
+		code = codes.MethodNotAllowed
+	case errors.Is(err, errUnauthorized):
+		code = codes.Unauthorized
+	default:
+		code = codes.InternalServerError
+	}
+
+	var seq *uint32
+	if obs, isObserve := r.Msg.Option(gocoap.Observe).(uint32); isObserve && err == nil && method == codes.GET && (res.root == coap.RootDevices || res.root == coap.RootGroups) && res.id != 0 {
+		switch obs {
+		case 0:
+			g.register(res, string(r.Msg.Token()), w)
+			n := atomic.AddUint32(&g.sequence, 1)
+			seq = &n
+		case 1:
+			g.deregister(res, string(r.Msg.Token()))
+		}
+	}
+
+	write(w, code, body, seq)
+
+	for _, res := range changed {
+		g.notify(res)
+	}
+}
+
+func (g *Gateway) auth(identity string, method codes.Code, payload []byte) (codes.Code, interface{}, error) {
+	if method != codes.POST {
+		return 0, nil, errMethodNotAllowed
+	}
+
+	if identity != AuthIdentity {
+		return 0, nil, errUnauthorized
+	}
+
+	var req struct {
+		Username string `json:"9090"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || req.Username == "" {
+		return 0, nil, errBadRequest
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, nil, err
+	}
+	psk := hex.EncodeToString(buf)
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if _, ok := g.identities[req.Username]; ok {
+		return 0, nil, errBadRequest
+	}
+	g.identities[req.Username] = psk
+
+	return codes.Created, struct {
+		PreSharedKey    string `json:"9091"`
+		FirmwareVersion string `json:"9029"`
+	}{
+		PreSharedKey:    psk,
+		FirmwareVersion: Firmware,
+	}, nil
+}
+
+func (g *Gateway) gatewayInfo(method codes.Code, payload []byte) (codes.Code, interface{}, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	switch method {
+	case codes.GET:
+		now := time.Now()
+		return codes.Content, coap.GatewayInfo{
+			ID:                 "fakegateway",
+			Firmware:           Firmware,
+			NTPServer:          g.ntpServer,
+			CurrentTime:        coap.Timestamp(now.Unix()),
+			CurrentTimeISO8601: now.UTC().Format(time.RFC3339),
+			FirstSetup:         coap.Timestamp(g.started.Unix()),
+		}, nil
+
+	case codes.PUT:
+		var req struct {
+			NTPServer *string `json:"9023"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return 0, nil, errBadRequest
+		}
+		if req.NTPServer != nil {
+			g.ntpServer = *req.NTPServer
+		}
+		return codes.Changed, nil, nil
+
+	default:
+		return 0, nil, errMethodNotAllowed
+	}
+}
+
+// reboot only counts reboots; the fake gateway stays available.
+func (g *Gateway) reboot(method codes.Code) (codes.Code, interface{}, error) {
+	if method != codes.POST {
+		return 0, nil, errMethodNotAllowed
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.reboots++
+	return codes.Changed, nil, nil
+}
+
+// checkFirmware always finds the firmware to be up to date.
+func (g *Gateway) checkFirmware(method codes.Code) (codes.Code, interface{}, error) {
+	if method != codes.POST {
+		return 0, nil, errMethodNotAllowed
+	}
+	return codes.Changed, nil, nil
+}
+
+func (g *Gateway) listDevices(method codes.Code) (codes.Code, interface{}, error) {
+	if method != codes.GET {
+		return 0, nil, errMethodNotAllowed
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	ids := []int{}
+	for id := range g.devices {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return codes.Content, ids, nil
+}
+
+func (g *Gateway) device(method codes.Code, id int, payload []byte) (codes.Code, interface{}, []resource, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	d, ok := g.devices[id]
+	if !ok {
+		return 0, nil, nil, errNotFound
+	}
+
+	switch method {
+	case codes.GET:
+		return codes.Content, d, nil, nil
+
+	case codes.PUT:
+		// Unlike groups, devices only take light control properties in the
+		// list of light controls, one per bulb.
+		var (
+			bare coap.LightControlInput
+			req  struct {
+				Name         *string                  `json:"9001"`
+				LightControl []coap.LightControlInput `json:"3311"`
+			}
+		)
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return 0, nil, nil, errBadRequest
+		}
+		if err := json.Unmarshal(payload, &bare); err != nil || bare != (coap.LightControlInput{}) {
+			return 0, nil, nil, errBadRequest
+		}
+		if req.Name != nil {
+			d.Name = *req.Name
+		}
+		for i, in := range req.LightControl {
+			if i < len(d.LightControl) {
+				applyLightControl(&d.LightControl[i], in)
+			}
+		}
+		return codes.Changed, nil, []resource{{root: coap.RootDevices, id: id}}, nil
+
+	case codes.DELETE:
+		delete(g.devices, id)
+		changed := []resource{{root: coap.RootDevices, id: id}}
+		for _, gr := range g.groups {
+			if !gr.GroupMembers.Contains(id) {
+				continue
+			}
+			var keep []int
+			for _, member := range gr.GroupMembers.HSLink.IDs {
+				if member != id {
+					keep = append(keep, member)
+				}
+			}
+			gr.GroupMembers = coap.NewGroupMembers(keep...)
+			changed = append(changed, resource{root: coap.RootGroups, id: gr.ID})
+		}
+		return codes.Deleted, nil, changed, nil
+
+	default:
+		return 0, nil, nil, errMethodNotAllowed
+	}
+}
+
+func (g *Gateway) listGroups(method codes.Code) (codes.Code, interface{}, error) {
+	if method != codes.GET {
+		return 0, nil, errMethodNotAllowed
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	ids := []int{}
+	for id := range g.groups {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return codes.Content, ids, nil
+}
+
+func (g *Gateway) createGroup(payload []byte) (codes.Code, interface{}, error) {
+	var req coap.GroupInput
+	if err := json.Unmarshal(payload, &req); err != nil || req.Name == nil {
+		return 0, nil, errBadRequest
+	}
+
+	var gr coap.Group
+	gr.Name = *req.Name
+	if req.Members != nil {
+		g.mtx.Lock()
+		for _, member := range req.Members.HSLink.IDs {
+			if _, ok := g.devices[member]; !ok {
+				g.mtx.Unlock()
+				return 0, nil, errBadRequest
+			}
+		}
+		g.mtx.Unlock()
+		gr.GroupMembers = *req.Members
+	}
+	id := g.AddGroup(gr)
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return codes.Created, g.groups[id].Resource, nil
+}
+
+func (g *Gateway) group(method codes.Code, id int, payload []byte) (codes.Code, interface{}, []resource, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	gr, ok := g.groups[id]
+	if !ok {
+		return 0, nil, nil, errNotFound
+	}
+
+	switch method {
+	case codes.GET:
+		return codes.Content, gr, nil, nil
+
+	case codes.PUT:
+		var req struct {
+			coap.LightControlInput
+			coap.GroupInput
+			MoodID *int `json:"9039"`
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return 0, nil, nil, errBadRequest
+		}
+		if req.Name != nil {
+			gr.Name = *req.Name
+		}
+		if req.Members != nil {
+			for _, member := range req.Members.HSLink.IDs {
+				if _, ok := g.devices[member]; !ok {
+					return 0, nil, nil, errBadRequest
+				}
+			}
+			gr.GroupMembers = *req.Members
+		}
+		var mood *coap.Mood
+		if req.MoodID != nil {
+			if mood = g.moods[id][*req.MoodID]; mood == nil {
+				return 0, nil, nil, errBadRequest
+			}
+			gr.MoodID = *req.MoodID
+		}
+		if req.State != nil {
+			gr.State = *req.State
+		}
+		if req.Dimmer != nil {
+			gr.Dimmer = *req.Dimmer
+		}
+		if req.LightColorHex != nil {
+			gr.LightColorHex = *req.LightColorHex
+		}
+
+		changed := []resource{{root: coap.RootGroups, id: id}}
+		for _, member := range gr.GroupMembers.HSLink.IDs {
+			d, ok := g.devices[member]
+			if !ok {
+				continue
+			}
+			for i := range d.LightControl {
+				if mood != nil {
+					applyMoodLight(&d.LightControl[i], d.ID, mood.Lights)
+				}
+				applyLightControl(&d.LightControl[i], req.LightControlInput)
+			}
+			changed = append(changed, resource{root: coap.RootDevices, id: member})
+		}
+		return codes.Changed, nil, changed, nil
+
+	case codes.DELETE:
+		delete(g.groups, id)
+		delete(g.moods, id)
+		return codes.Deleted, nil, []resource{{root: coap.RootGroups, id: id}}, nil
+
+	default:
+		return 0, nil, nil, errMethodNotAllowed
+	}
+}
+
+func (g *Gateway) groupMoods(method codes.Code, groupID int, payload []byte) (codes.Code, interface{}, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if _, ok := g.groups[groupID]; !ok {
+		return 0, nil, errNotFound
+	}
+
+	switch method {
+	case codes.GET:
+		ids := []int{}
+		for id := range g.moods[groupID] {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return codes.Content, ids, nil
+
+	case codes.POST:
+		var req coap.MoodInput
+		if err := json.Unmarshal(payload, &req); err != nil || req.Name == nil {
+			return 0, nil, errBadRequest
+		}
+		id := g.addMood(groupID, coap.Mood{
+			Resource: coap.Resource{Name: *req.Name},
+			Lights:   req.Lights,
+		})
+		return codes.Created, g.moods[groupID][id].Resource, nil
+
+	default:
+		return 0, nil, errMethodNotAllowed
+	}
+}
+
+func (g *Gateway) mood(method codes.Code, groupID, moodID int, payload []byte) (codes.Code, interface{}, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	m, ok := g.moods[groupID][moodID]
+	if !ok {
+		return 0, nil, errNotFound
+	}
+
+	switch method {
+	case codes.GET:
+		return codes.Content, m, nil
+
+	case codes.PUT:
+		var req coap.MoodInput
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return 0, nil, errBadRequest
+		}
+		if req.Name != nil {
+			m.Name = *req.Name
+		}
+		if req.Lights != nil {
+			m.Lights = req.Lights
+		}
+		return codes.Changed, nil, nil
+
+	case codes.DELETE:
+		delete(g.moods[groupID], moodID)
+		return codes.Deleted, nil, nil
+
+	default:
+		return 0, nil, errMethodNotAllowed
+	}
+}
+
+func (g *Gateway) listTasks(method codes.Code, payload []byte) (codes.Code, interface{}, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	switch method {
+	case codes.GET:
+		ids := []int{}
+		for id := range g.tasks {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		return codes.Content, ids, nil
+
+	case codes.POST:
+		var req coap.SmartTaskInput
+		if err := json.Unmarshal(payload, &req); err != nil || req.Type == 0 || req.StartAction == nil || len(req.Triggers) == 0 {
+			return 0, nil, errBadRequest
+		}
+		t := &coap.SmartTask{Resource: coap.Resource{ID: g.nextTask, CreatedAt: coap.Timestamp(time.Now().Unix())}, Type: req.Type, Enabled: 1}
+		applySmartTask(t, req)
+		g.tasks[t.ID] = t
+		g.nextTask++
+		return codes.Created, t.Resource, nil
+
+	default:
+		return 0, nil, errMethodNotAllowed
+	}
+}
+
+func (g *Gateway) task(method codes.Code, id int, payload []byte) (codes.Code, interface{}, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	t, ok := g.tasks[id]
+	if !ok {
+		return 0, nil, errNotFound
+	}
+
+	switch method {
+	case codes.GET:
+		return codes.Content, t, nil
+
+	case codes.PUT:
+		var req coap.SmartTaskInput
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return 0, nil, errBadRequest
+		}
+		applySmartTask(t, req)
+		return codes.Changed, nil, nil
+
+	case codes.DELETE:
+		delete(g.tasks, id)
+		return codes.Deleted, nil, nil
+
+	default:
+		return 0, nil, errMethodNotAllowed
+	}
+}
+
+func applySmartTask(t *coap.SmartTask, in coap.SmartTaskInput) {
+	if in.Type != 0 {
+		t.Type = in.Type
+	}
+	if in.Enabled != nil {
+		t.Enabled = *in.Enabled
+	}
+	if in.RepeatDays != nil {
+		t.RepeatDays = *in.RepeatDays
+	}
+	if in.StartAction != nil {
+		t.StartAction = *in.StartAction
+	}
+	if in.Triggers != nil {
+		t.Triggers = in.Triggers
+	}
+}
+
+func applyMoodLight(lc *coap.LightControl, id int, lights []coap.MoodLight) {
+	for _, l := range lights {
+		if l.ID != id {
+			continue
+		}
+		lc.State, lc.Dimmer = l.State, l.Dimmer
+		if l.LightColorHex != "" {
+			lc.LightColorHex = l.LightColorHex
+		}
+		if l.LightColorX != 0 || l.LightColorY != 0 {
+			lc.LightColorX, lc.LightColorY = l.LightColorX, l.LightColorY
+		}
+		if l.LightMireds != 0 {
+			lc.LightMireds = l.LightMireds
+		}
+	}
+}
+
+func applyLightControl(lc *coap.LightControl, in coap.LightControlInput) {
+	if in.State != nil {
+		lc.State = *in.State
+	}
+	if in.Dimmer != nil {
+		lc.Dimmer = *in.Dimmer
+	}
+	if in.LightColorHex != nil {
+		lc.LightColorHex = *in.LightColorHex
+	}
+	if in.Hue != nil {
+		lc.Hue = *in.Hue
+	}
+	if in.Saturation != nil {
+		lc.Saturation = *in.Saturation
+	}
+	if in.LightColorX != nil {
+		lc.LightColorX = *in.LightColorX
+	}
+	if in.LightColorY != nil {
+		lc.LightColorY = *in.LightColorY
+	}
+	if in.LightMireds != nil {
+		lc.LightMireds = *in.LightMireds
+	}
+}
+
+//
+//
+//
+
+// resource identifies a device, group, or the moods of a group, or a list of
+// them if id is zero. For moods, id is the group ID, and sub the mood ID.
+type resource struct {
+	root int
+	id   int
+	sub  int
+}
+
+func parseResource(path []string) (resource, bool) {
+	if len(path) < 1 || len(path) > 3 {
+		return resource{}, false
+	}
+
+	ids := make([]int, len(path))
+	for i, p := range path {
+		id, err := strconv.Atoi(p)
+		if err != nil || id == 0 {
+			return resource{}, false
+		}
+		ids[i] = id
+	}
+
+	switch root := ids[0]; {
+	case (root == coap.RootDevices || root == coap.RootGroups || root == coap.RootSmartTasks) && len(ids) <= 2:
+	case root == coap.RootMoods:
+	default:
+		return resource{}, false
+	}
+
+	var res resource
+	res.root = ids[0]
+	if len(ids) > 1 {
+		res.id = ids[1]
+	}
+	if len(ids) > 2 {
+		res.sub = ids[2]
+	}
+	return res, true
+}
+
+func (g *Gateway) register(res resource, token string, w gocoap.ResponseWriter) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.observers[res] == nil {
+		g.observers[res] = map[string]gocoap.ResponseWriter{}
+	}
+	g.observers[res][token] = w
+}
+
+func (g *Gateway) deregister(res resource, token string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	delete(g.observers[res], token)
+}
+
+// notify sends the current representation of res to each of its observers.
+// If res no longer exists, they're sent 4.04 Not Found, which ends their
+// observations. Observers that can't be reached are dropped.
+func (g *Gateway) notify(res resource) {
+	g.mtx.Lock()
+	var body interface{}
+	switch res.root {
+	case coap.RootDevices:
+		if d, ok := g.devices[res.id]; ok {
+			body = *d
+		}
+	case coap.RootGroups:
+		if gr, ok := g.groups[res.id]; ok {
+			body = *gr
+		}
+	}
+	observers := map[string]gocoap.ResponseWriter{}
+	for token, w := range g.observers[res] {
+		observers[token] = w
+	}
+	g.mtx.Unlock()
+
+	code := codes.Content
+	if body == nil {
+		code = codes.NotFound
+	}
+
+	for token, w := range observers {
+		seq := atomic.AddUint32(&g.sequence, 1)
+		if err := write(&notifier{w}, code, body, &seq); err != nil || body == nil {
+			g.deregister(res, token)
+		}
+	}
+}
+
+// notifier turns responses into non-confirmable notifications with fresh
+// message IDs, so that the client doesn't treat them as duplicates of the
+// response to the original observe request.
+type notifier struct{ gocoap.ResponseWriter }
+
+func (n *notifier) NewResponse(code codes.Code) gocoap.Message {
+	msg := n.ResponseWriter.NewResponse(code)
+	msg.SetType(gocoap.NonConfirmable)
+	msg.SetMessageID(gocoap.GenerateMessageID())
+	return msg
+}
+
+// write sends a response with the JSON-encoded body, if any. If seq is non-nil
+// and the response is a success, it's sent with the observe option.
+func write(w interface {
+	NewResponse(codes.Code) gocoap.Message
+	WriteMsg(gocoap.Message) error
+}, code codes.Code, body interface{}, seq *uint32) error {
+	msg := w.NewResponse(code)
+	if seq != nil && code < codes.BadRequest {
+		msg.SetOption(gocoap.Observe, *seq)
+	}
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		msg.SetOption(gocoap.ContentFormat, gocoap.AppJSON)
+		msg.SetPayload(buf)
+	}
+	return w.WriteMsg(msg)
+}
@@ -0,0 +1,158 @@
+package fakegateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-ocf/go-coap/codes"
+	"github.com/peterbourgon/lightctl/pkg/coap"
+)
+
+func TestDevicePut(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload string
+		want    codes.Code
+		state   coap.OnOff
+		dimmer  coap.Percent255
+	}{
+		{"light control", `{"3311":[{"5850":0,"5851":127}]}`, codes.Changed, 0, 127},
+		{"name", `{"9001":"Hallway"}`, codes.Changed, 1, 254},
+		{"bare light control", `{"5850":0,"5851":127}`, codes.BadRequest, 1, 254},
+		{"bare and wrapped", `{"5850":0,"3311":[{"5851":127}]}`, codes.BadRequest, 1, 254},
+		{"invalid", `{"3311":{"5850":0}}`, codes.BadRequest, 1, 254},
+	} {
+		g := New("0123456789abcdef")
+		id := g.AddDevice(testLight("Kitchen ceiling"))
+
+		code, _, _, err := g.device(codes.PUT, id, []byte(tc.payload))
+		if have := responseCode(code, err); tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+
+		d, _ := g.Device(id)
+		if lc := d.LightControl[0]; tc.state != lc.State || tc.dimmer != lc.Dimmer {
+			t.Errorf("%s: want state %d dimmer %d, have state %d dimmer %d", tc.name, tc.state, tc.dimmer, lc.State, lc.Dimmer)
+		}
+	}
+}
+
+func TestCreateGroup(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload string
+		want    codes.Code
+	}{
+		{"members", `{"9001":"Kitchen","9018":{"15002":{"9003":[65536,65537]}}}`, codes.Created},
+		{"no members", `{"9001":"Kitchen"}`, codes.Created},
+		{"unknown member", `{"9001":"Kitchen","9018":{"15002":{"9003":[65536,70000]}}}`, codes.BadRequest},
+		{"no name", `{"9018":{"15002":{"9003":[65536]}}}`, codes.BadRequest},
+	} {
+		g := New("0123456789abcdef")
+		g.AddDevice(testLight("Kitchen ceiling"))
+		g.AddDevice(testLight("Kitchen counter"))
+
+		code, _, err := g.createGroup([]byte(tc.payload))
+		if have := responseCode(code, err); tc.want != have {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+
+		var groups int
+		if tc.want == codes.Created {
+			groups = 1
+		}
+		if want, have := groups, len(g.groups); want != have {
+			t.Errorf("%s: want %d groups, have %d", tc.name, want, have)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	g := New("0123456789abcdef")
+	var (
+		ceiling = g.AddDevice(testLight("Kitchen ceiling"))
+		kitchen = g.AddGroup(coap.Group{Resource: coap.Resource{Name: "Kitchen"}, GroupMembers: coap.NewGroupMembers(ceiling)})
+	)
+	g.AddIdentity("test", "secret")
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	client, err := coap.NewClient(ctx, "udp", g.Addr().String(), "test", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	devices, err := client.ObserveDevice(ctx, ceiling)
+	if err != nil {
+		t.Fatalf("ObserveDevice: %v", err)
+	}
+	if d := <-devices; d.Name != "Kitchen ceiling" {
+		t.Errorf("first notification: want current state, have %+v", d)
+	}
+
+	// Changes to the group are notified to observers of its members.
+	dimmer := coap.Percent255(127)
+	if err := client.SetLightControl(ctx, coap.RootGroups, kitchen, coap.LightControlInput{Dimmer: &dimmer}); err != nil {
+		t.Fatalf("SetLightControl: %v", err)
+	}
+	if d := <-devices; d.LightControl[0].Dimmer != dimmer {
+		t.Errorf("after setting the group: want dimmer %d, have %d", dimmer, d.LightControl[0].Dimmer)
+	}
+
+	// Changes by other clients are notified too.
+	d, _ := g.Device(ceiling)
+	d.Name = "Hallway"
+	if err := g.UpdateDevice(d); err != nil {
+		t.Fatal(err)
+	}
+	if d := <-devices; d.Name != "Hallway" {
+		t.Errorf("after UpdateDevice: want name Hallway, have %q", d.Name)
+	}
+
+	// Removing the device ends the observation with 4.04 Not Found.
+	if err := client.RemoveDevice(ctx, ceiling); err != nil {
+		t.Fatalf("RemoveDevice: %v", err)
+	}
+	select {
+	case d, ok := <-devices:
+		if ok {
+			t.Errorf("after RemoveDevice: want closed stream, have %+v", d)
+		}
+	case <-ctx.Done():
+		t.Fatal("after RemoveDevice: stream wasn't closed")
+	}
+	g.mtx.Lock()
+	observers := len(g.observers[resource{root: coap.RootDevices, id: ceiling}])
+	g.mtx.Unlock()
+	if want, have := 0, observers; want != have {
+		t.Errorf("after RemoveDevice: want %d observers, have %d", want, have)
+	}
+}
+
+func responseCode(code codes.Code, err error) codes.Code {
+	switch err {
+	case nil:
+		return code
+	case errNotFound:
+		return codes.NotFound
+	case errBadRequest:
+		return codes.BadRequest
+	default:
+		return codes.InternalServerError
+	}
+}
+
+func testLight(name string) coap.Device {
+	var d coap.Device
+	d.Name = name
+	d.Reachable = 1
+	d.LightControl = []coap.LightControl{{State: 1, Dimmer: 254, LightMireds: 370}}
+	return d
+}
@@ -0,0 +1,161 @@
+package fakegateway
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// packetListener demultiplexes a UDP socket into one connection per remote
+// address, so that each connection can be handed to dtls.Server. It's used
+// instead of dtls.Listen, which performs handshakes serially in Accept, and
+// can deadlock when a handshake fails while another client is connecting.
+type packetListener struct {
+	pc     net.PacketConn
+	accept chan *packetConn
+	done   chan struct{}
+
+	mtx   sync.Mutex
+	conns map[string]*packetConn
+}
+
+func listenPacket(address string) (*packetListener, error) {
+	pc, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &packetListener{
+		pc:     pc,
+		accept: make(chan *packetConn),
+		done:   make(chan struct{}),
+		conns:  map[string]*packetConn{},
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// Accept returns a connection for each new remote address.
+func (l *packetListener) Accept() (*packetConn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *packetListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// Close stops reading from the socket. Connections already accepted are
+// unusable afterwards, but must still be closed by their owners.
+func (l *packetListener) Close() error {
+	select {
+	case <-l.done:
+		return nil
+	default:
+		close(l.done)
+		return l.pc.Close()
+	}
+}
+
+func (l *packetListener) readLoop() {
+	buf := make([]byte, 8192)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		l.mtx.Lock()
+		c, ok := l.conns[addr.String()]
+		if !ok {
+			c = &packetConn{
+				l:     l,
+				raddr: addr,
+				inbox: make(chan []byte, 64),
+				done:  make(chan struct{}),
+			}
+			l.conns[addr.String()] = c
+		}
+		l.mtx.Unlock()
+
+		if !ok {
+			select {
+			case l.accept <- c:
+			case <-l.done:
+				return
+			}
+		}
+
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		select {
+		case c.inbox <- packet:
+		default: // full, drop it like the network would
+		}
+	}
+}
+
+func (l *packetListener) forget(c *packetConn) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.conns[c.raddr.String()] == c {
+		delete(l.conns, c.raddr.String())
+	}
+}
+
+// packetConn is the connection with a single remote address. Deadlines aren't
+// supported; DTLS and CoAP manage their own timeouts.
+type packetConn struct {
+	l     *packetListener
+	raddr net.Addr
+	inbox chan []byte
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (c *packetConn) Read(p []byte) (int, error) {
+	select {
+	case packet := <-c.inbox:
+		return copy(p, packet), nil
+	case <-c.done:
+		return 0, io.EOF
+	}
+}
+
+func (c *packetConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+		return c.l.pc.WriteTo(p, c.raddr)
+	}
+}
+
+// Close forgets the remote address, so that subsequent packets from it are
+// treated as a new connection.
+func (c *packetConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.l.forget(c)
+	})
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr                { return c.l.pc.LocalAddr() }
+func (c *packetConn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *packetConn) SetDeadline(t time.Time) error      { return nil }
+func (c *packetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *packetConn) SetWriteDeadline(t time.Time) error { return nil }
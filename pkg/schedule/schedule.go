@@ -0,0 +1,253 @@
+// Package schedule parses rules that set lights at times of day, solar events,
+// or cron expressions, and computes when they fire.
+//
+// A rules file has one rule per line. Empty lines, and lines starting with #,
+// are ignored. A rule is a trigger followed by an action, e.g.
+//
+//	at sunset-30m set group Porch on 80%
+//	weekdays 06:45 fade Bedroom to 100% over 15m
+//	mon,wed-fri at dusk set "Living room" on 40% white 20
+//	cron 0 23 * * * set group Porch off
+//
+// Triggers are a time of day, or a solar event with an optional offset, e.g.
+// sunrise+1h, optionally preceded by daily (the default), weekdays, weekends,
+// or a list of days; or a 5-field cron expression after the word cron. The
+// word at is optional.
+//
+// Actions are set, which applies any combination of on, off, a level in
+// percent, white 0..100 (0=red, 100=white), and over with a transition time;
+// and fade, which is set with a required transition. The word group before the
+// group name, and the word to, are optional. Group names with spaces must be
+// quoted, and may be glob patterns.
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is a single line of a rules file.
+type Rule struct {
+	Line    int    // line number in the rules file
+	Text    string // the rule as written
+	Trigger Trigger
+	Action  Action
+}
+
+// Next returns the first firing of the rule strictly after the given time.
+func (r Rule) Next(after time.Time) time.Time {
+	return r.Trigger.Next(after)
+}
+
+func (r Rule) String() string {
+	return r.Text
+}
+
+// Action is a change to the lights of a group. Properties that are nil are left
+// unchanged.
+type Action struct {
+	Group      string // group name or glob pattern
+	On         *bool
+	Level      *int // 0..100
+	White      *int // 0..100 (0=red, 100=white)
+	Transition time.Duration
+}
+
+// Parse reads rules, one per line. The location is required for rules with
+// solar triggers; it may be nil if there are none.
+func Parse(r io.Reader, location *Location) ([]Rule, error) {
+	var (
+		rules   []Rule
+		scanner = bufio.NewScanner(r)
+		line    int
+	)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := parseRule(text, location)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule.Line = line
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRule(text string, location *Location) (Rule, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	trigger, rest, err := parseTrigger(tokens, location)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	action, err := parseAction(rest)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	return Rule{Text: text, Trigger: trigger, Action: action}, nil
+}
+
+func parseTrigger(tokens []string, location *Location) (Trigger, []string, error) {
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "cron") {
+		if len(tokens) < 6 {
+			return nil, nil, fmt.Errorf("cron expression must have 5 fields")
+		}
+		t, err := parseCron(tokens[1:6])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+		return t, tokens[6:], nil
+	}
+
+	d := allDays
+	tokens = skip(tokens, "at")
+	if len(tokens) > 0 {
+		if parsed, ok := parseDays(tokens[0]); ok {
+			d, tokens = parsed, skip(tokens[1:], "at")
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("missing time")
+	}
+
+	if hour, minute, ok := parseClock(tokens[0]); ok {
+		return clockTrigger{days: d, hour: hour, minute: minute}, tokens[1:], nil
+	}
+
+	if event, offset, ok := parseSolar(tokens[0]); ok {
+		if location == nil {
+			return nil, nil, fmt.Errorf("%s requires a location (latitude and longitude)", tokens[0])
+		}
+		return solarTrigger{days: d, event: event, offset: offset, location: *location}, tokens[1:], nil
+	}
+
+	return nil, nil, fmt.Errorf("invalid time %q (HH:MM, or a solar event like sunset-30m)", tokens[0])
+}
+
+func parseAction(tokens []string) (Action, error) {
+	if len(tokens) == 0 {
+		return Action{}, fmt.Errorf("missing action")
+	}
+
+	verb := tokens[0]
+	if verb != "set" && verb != "fade" {
+		return Action{}, fmt.Errorf("invalid action %q (set, fade)", verb)
+	}
+
+	tokens = skip(tokens[1:], "group")
+	if len(tokens) == 0 {
+		return Action{}, fmt.Errorf("missing group name")
+	}
+
+	var (
+		a       = Action{Group: tokens[0]}
+		hasOver bool
+		on, off = true, false
+	)
+	for tokens = tokens[1:]; len(tokens) > 0; tokens = tokens[1:] {
+		switch tok := tokens[0]; {
+		case tok == "to":
+		case tok == "on":
+			a.On = &on
+		case tok == "off":
+			a.On = &off
+		case strings.HasSuffix(tok, "%"):
+			level, err := strconv.Atoi(strings.TrimSuffix(tok, "%"))
+			if err != nil || level < 0 || level > 100 {
+				return Action{}, fmt.Errorf("invalid level %q (0%%..100%%)", tok)
+			}
+			a.Level = &level
+		case tok == "white":
+			if len(tokens) < 2 {
+				return Action{}, fmt.Errorf("missing white value")
+			}
+			white, err := strconv.Atoi(tokens[1])
+			if err != nil || white < 0 || white > 100 {
+				return Action{}, fmt.Errorf("invalid white %q (0..100)", tokens[1])
+			}
+			a.White, tokens = &white, tokens[1:]
+		case tok == "over":
+			if len(tokens) < 2 {
+				return Action{}, fmt.Errorf("missing transition time")
+			}
+			d, err := time.ParseDuration(tokens[1])
+			if err != nil || d < 0 {
+				return Action{}, fmt.Errorf("invalid transition time %q", tokens[1])
+			}
+			a.Transition, hasOver, tokens = d, true, tokens[1:]
+		default:
+			return Action{}, fmt.Errorf("unexpected %q", tok)
+		}
+	}
+
+	switch {
+	case a.On != nil && !*a.On && (a.Level != nil || a.White != nil):
+		return Action{}, fmt.Errorf("off can't be combined with a level or white")
+	case a.On == nil && a.Level == nil && a.White == nil:
+		return Action{}, fmt.Errorf("nothing to set (on, off, level%%, white)")
+	case verb == "fade" && !hasOver:
+		return Action{}, fmt.Errorf("fade requires a transition time (over)")
+	case verb == "fade" && a.Level == nil && a.White == nil:
+		return Action{}, fmt.Errorf("fade requires a level or white")
+	}
+
+	return a, nil
+}
+
+// skip drops the first token if it's the given optional word.
+func skip(tokens []string, word string) []string {
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], word) {
+		return tokens[1:]
+	}
+	return tokens
+}
+
+// tokenize splits a rule into words. Double quotes group words, e.g. a group
+// name with spaces.
+func tokenize(s string) ([]string, error) {
+	var (
+		tokens []string
+		b      strings.Builder
+		quoted bool
+		inWord bool
+	)
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted, inWord = !quoted, true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inWord {
+				tokens = append(tokens, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		tokens = append(tokens, b.String())
+	}
+	return tokens, nil
+}
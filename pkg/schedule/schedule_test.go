@@ -0,0 +1,90 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	location := &Location{Latitude: 52.520008, Longitude: 13.404954}
+
+	for _, tc := range []struct {
+		name     string
+		input    string
+		location *Location
+		want     string // action as %+v, or the error
+	}{
+		{"set", "at 06:45 set group Porch on 80%", nil, "Porch on=true level=80 white=- over=0s"},
+		{"fade", "weekdays 06:45 fade Bedroom to 100% over 15m", nil, "Bedroom on=- level=100 white=- over=15m0s"},
+		{"quoted", `mon,wed-fri at dusk set "Living room" on 40% white 20`, location, "Living room on=true level=40 white=20 over=0s"},
+		{"cron", "cron 0 23 * * * set group Porch off", nil, "Porch on=false level=- white=- over=0s"},
+		{"day names in any case", "Mon,Wed-Fri At 06:45 set Porch on", nil, "Porch on=true level=- white=- over=0s"},
+		{"day keyword in any case", "WEEKDAYS 06:45 set Porch on", nil, "Porch on=true level=- white=- over=0s"},
+		{"cron in any case", "CRON 0 23 * * * set Porch off", nil, "Porch on=false level=- white=- over=0s"},
+		{"comments", "# porch\n\n  at 06:45 set Porch on\n", nil, "Porch on=true level=- white=- over=0s"},
+		{"solar without location", "at sunset set Porch on", nil, "line 1: sunset requires a location (latitude and longitude)"},
+		{"missing time", "weekdays set Porch on", nil, `line 1: invalid time "set" (HH:MM, or a solar event like sunset-30m)`},
+		{"invalid time", "at 25:00 set Porch on", nil, `line 1: invalid time "25:00" (HH:MM, or a solar event like sunset-30m)`},
+		{"short cron", "cron 0 23 * *", nil, "line 1: cron expression must have 5 fields"},
+		{"invalid cron", "cron 0 23 30 2 8 set Porch on", nil, `line 1: invalid cron expression: day of week: "8" is out of range 0-7`},
+		{"missing action", "at 06:45", nil, "line 1: missing action"},
+		{"invalid action", "at 06:45 toggle Porch", nil, `line 1: invalid action "toggle" (set, fade)`},
+		{"missing group", "at 06:45 set group", nil, "line 1: missing group name"},
+		{"nothing to set", "at 06:45 set Porch", nil, "line 1: nothing to set (on, off, level%, white)"},
+		{"off with level", "at 06:45 set Porch off 50%", nil, "line 1: off can't be combined with a level or white"},
+		{"invalid level", "at 06:45 set Porch 101%", nil, `line 1: invalid level "101%" (0%..100%)`},
+		{"invalid white", "at 06:45 set Porch white x", nil, `line 1: invalid white "x" (0..100)`},
+		{"fade without over", "at 06:45 fade Porch 50%", nil, "line 1: fade requires a transition time (over)"},
+		{"fade only on", "at 06:45 fade Porch on over 1m", nil, "line 1: fade requires a level or white"},
+		{"unterminated quote", `at 06:45 set "Porch on`, nil, "line 1: unterminated quote"},
+		{"error line", "# porch\nat 06:45 set Porch\n", nil, "line 2: nothing to set (on, off, level%, white)"},
+	} {
+		rules, err := Parse(strings.NewReader(tc.input), tc.location)
+		var have string
+		switch {
+		case err != nil:
+			have = err.Error()
+		case len(rules) != 1:
+			have = fmt.Sprintf("%d rules", len(rules))
+		default:
+			have = formatAction(rules[0].Action)
+		}
+		if tc.want != have {
+			t.Errorf("%s: want %q, have %q", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestParseNext(t *testing.T) {
+	rules, err := Parse(strings.NewReader("weekends 09:30 set Kitchen on\ncron 0 7 * * 1-5 set Kitchen on\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC) // a Friday
+	for i, want := range []time.Time{
+		time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC),
+		time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC),
+	} {
+		if have := rules[i].Next(after); !want.Equal(have) {
+			t.Errorf("line %d %q: want %s, have %s", rules[i].Line, rules[i].Text, want, have)
+		}
+	}
+}
+
+func formatAction(a Action) string {
+	on, level, white := "-", "-", "-"
+	if a.On != nil {
+		on = strconv.FormatBool(*a.On)
+	}
+	if a.Level != nil {
+		level = strconv.Itoa(*a.Level)
+	}
+	if a.White != nil {
+		white = strconv.Itoa(*a.White)
+	}
+	return fmt.Sprintf("%s on=%s level=%s white=%s over=%s", a.Group, on, level, white, a.Transition)
+}
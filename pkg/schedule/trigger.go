@@ -0,0 +1,278 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/lightctl/pkg/circadian"
+)
+
+// Trigger computes when a rule fires.
+type Trigger interface {
+	// Next returns the first firing strictly after the given time, in the
+	// location of that time, or the zero time if the trigger never fires.
+	Next(after time.Time) time.Time
+}
+
+// Location is the place for which solar events are calculated.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// maxSearchDays bounds the search for the next firing. It's long enough for a
+// solar event that doesn't occur for months, e.g. sunset above the arctic
+// circle, and for any valid cron expression, e.g. February 29.
+const maxSearchDays = 4*366 + 1
+
+// days is a set of weekdays, indexed by time.Weekday.
+type days [7]bool
+
+var allDays = days{true, true, true, true, true, true, true}
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseDays parses daily, weekdays, weekends, or a comma-separated list of
+// day names and ranges of day names, e.g. mon,wed-fri, in any case.
+func parseDays(s string) (days, bool) {
+	s = strings.ToLower(s)
+	switch s {
+	case "daily":
+		return allDays, true
+	case "weekdays":
+		return days{false, true, true, true, true, true, false}, true
+	case "weekends":
+		return days{true, false, false, false, false, false, true}, true
+	}
+
+	var d days
+	for _, part := range strings.Split(s, ",") {
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		from, to := dayIndex(first), dayIndex(last)
+		if from < 0 || to < 0 {
+			return days{}, false
+		}
+		for i := from; ; i = (i + 1) % 7 {
+			d[i] = true
+			if i == to {
+				break
+			}
+		}
+	}
+	return d, true
+}
+
+func dayIndex(name string) int {
+	for i, n := range dayNames {
+		if name == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// clockTrigger fires at a time of day, on some days of the week.
+type clockTrigger struct {
+	days         days
+	hour, minute int
+}
+
+func (t clockTrigger) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for i := 0; i <= 7; i++ {
+		next := time.Date(year, month, day+i, t.hour, t.minute, 0, 0, after.Location())
+		if next.After(after) && t.days[next.Weekday()] {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// solarTrigger fires at an offset from a solar event, on some days of the
+// week. The day is that of the event, not of the firing.
+type solarTrigger struct {
+	days     days
+	event    string
+	offset   time.Duration
+	location Location
+}
+
+func (t solarTrigger) Next(after time.Time) time.Time {
+	// Start a day early, as the offset may move the firing to the next day.
+	year, month, day := after.Date()
+	for i := -1; i <= maxSearchDays; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, after.Location())
+		if !t.days[date.Weekday()] {
+			continue
+		}
+		for _, e := range circadian.Events(date, t.location.Latitude, t.location.Longitude) {
+			if e.Name != t.event {
+				continue
+			}
+			if next := e.Time.Add(t.offset).Truncate(time.Second); next.After(after) {
+				return next
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// parseSolar parses an event name with an optional offset, e.g. sunset,
+// sunset-30m, or dawn+1h15m.
+func parseSolar(s string) (event string, offset time.Duration, ok bool) {
+	event = s
+	if i := strings.IndexAny(s, "+-"); i >= 0 {
+		d, err := time.ParseDuration(s[i:])
+		if err != nil {
+			return "", 0, false
+		}
+		event, offset = s[:i], d
+	}
+	for _, name := range circadian.EventNames {
+		if event == name {
+			return event, offset, true
+		}
+	}
+	return "", 0, false
+}
+
+// parseClock parses a time of day, e.g. 06:45.
+func parseClock(s string) (hour, minute int, ok bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, false
+	}
+	return t.Hour(), t.Minute(), true
+}
+
+// cronTrigger fires according to a standard 5-field cron expression: minute,
+// hour, day of month, month, and day of week (0 or 7 is Sunday). Each field
+// is *, or a comma-separated list of values and ranges, with an optional step,
+// e.g. */15 or 1-5.
+type cronTrigger struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+func parseCron(fields []string) (cronTrigger, error) {
+	if len(fields) != 5 {
+		return cronTrigger{}, fmt.Errorf("cron expression must have 5 fields")
+	}
+
+	var (
+		t   cronTrigger
+		err error
+	)
+	if t.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronTrigger{}, fmt.Errorf("minute: %w", err)
+	}
+	if t.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronTrigger{}, fmt.Errorf("hour: %w", err)
+	}
+	if t.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronTrigger{}, fmt.Errorf("day of month: %w", err)
+	}
+	if t.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronTrigger{}, fmt.Errorf("month: %w", err)
+	}
+	if t.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronTrigger{}, fmt.Errorf("day of week: %w", err)
+	}
+	t.dow[0] = t.dow[0] || t.dow[7]
+	t.domAny, t.dowAny = fields[2] == "*", fields[4] == "*"
+	return t, nil
+}
+
+// parseCronField returns the set of values of a field, indexed by value.
+func parseCronField(s string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			part, step = part[:i], n
+		}
+
+		first, last := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			a, errA := strconv.Atoi(part[:i])
+			b, errB := strconv.Atoi(part[i+1:])
+			if errA != nil || errB != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			first, last = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			first, last = n, n
+			if step > 1 {
+				last = max // n/step means from n to the end, in steps
+			}
+		}
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+
+		for v := first; v <= last; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (t cronTrigger) Next(after time.Time) time.Time {
+	year, month, day := after.Date()
+	for i := 0; i <= maxSearchDays; i++ {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, after.Location())
+		if !t.month[date.Month()] || !t.matchesDay(date) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if !t.hour[h] {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if !t.minute[m] {
+					continue
+				}
+				next := time.Date(date.Year(), date.Month(), date.Day(), h, m, 0, 0, after.Location())
+				if next.After(after) && next.Day() == date.Day() {
+					return next
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay implements the cron rule that, if both day of month and day of
+// week are restricted, a day matching either of them matches.
+func (t cronTrigger) matchesDay(date time.Time) bool {
+	var (
+		dom = t.dom[date.Day()]
+		dow = t.dow[date.Weekday()]
+	)
+	switch {
+	case t.domAny && t.dowAny:
+		return true
+	case t.domAny:
+		return dow
+	case t.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,195 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		want  days
+		ok    bool
+	}{
+		{"daily", allDays, true},
+		{"weekdays", days{false, true, true, true, true, true, false}, true},
+		{"weekends", days{true, false, false, false, false, false, true}, true},
+		{"mon", days{false, true, false, false, false, false, false}, true},
+		{"mon,wed-fri", days{false, true, false, true, true, true, false}, true},
+		{"fri-mon", days{true, true, false, false, false, true, true}, true}, // wraps around
+		{"sun,sat", days{true, false, false, false, false, false, true}, true},
+		{"monday", days{}, false},
+		{"mon,", days{}, false},
+		{"mon-", days{}, false},
+		{"Mon", days{false, true, false, false, false, false, false}, true},
+		{"MON,Wed-FRI", days{false, true, false, true, true, true, false}, true},
+		{"Weekends", days{true, false, false, false, false, false, true}, true},
+		{"06:45", days{}, false},
+	} {
+		have, ok := parseDays(tc.input)
+		if tc.ok != ok || tc.want != have {
+			t.Errorf("%q: want %v %v, have %v %v", tc.input, tc.want, tc.ok, have, ok)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, tc := range []struct {
+		input   []string
+		wantErr bool
+	}{
+		{[]string{"*", "*", "*", "*", "*"}, false},
+		{[]string{"0", "23", "*", "*", "*"}, false},
+		{[]string{"*/15", "6-22/2", "1,15", "1-12", "mon"}, true}, // no day names
+		{[]string{"*/15", "6-22/2", "1,15", "1-12", "1-5"}, false},
+		{[]string{"5/10", "*", "*", "*", "0,7"}, false},
+		{[]string{"60", "*", "*", "*", "*"}, true},
+		{[]string{"*", "24", "*", "*", "*"}, true},
+		{[]string{"*", "*", "0", "*", "*"}, true},
+		{[]string{"*", "*", "32", "*", "*"}, true},
+		{[]string{"*", "*", "*", "13", "*"}, true},
+		{[]string{"*", "*", "*", "*", "8"}, true},
+		{[]string{"5-1", "*", "*", "*", "*"}, true},
+		{[]string{"*/0", "*", "*", "*", "*"}, true},
+		{[]string{"1-2-3", "*", "*", "*", "*"}, true},
+		{[]string{"", "*", "*", "*", "*"}, true},
+		{[]string{"*", "*", "*", "*"}, true},
+	} {
+		if _, err := parseCron(tc.input); tc.wantErr != (err != nil) {
+			t.Errorf("%q: want error %v, have %v", tc.input, tc.wantErr, err)
+		}
+	}
+}
+
+func TestParseCronField(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"*/2", 0, 6, []int{0, 2, 4, 6}},
+		{"3", 0, 6, []int{3}},
+		{"3/2", 0, 6, []int{3, 5}},
+		{"1-3", 0, 6, []int{1, 2, 3}},
+		{"1-5/2", 0, 6, []int{1, 3, 5}},
+		{"0,2-3,6", 0, 6, []int{0, 2, 3, 6}},
+		{"*/5", 1, 12, []int{1, 6, 11}},
+	} {
+		set, err := parseCronField(tc.input, tc.min, tc.max)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		var have []int
+		for v, ok := range set {
+			if ok {
+				have = append(have, v)
+			}
+		}
+		if !equalInts(tc.want, have) {
+			t.Errorf("%q: want %v, have %v", tc.input, tc.want, have)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, berlin)
+	}
+	cron := func(fields ...string) Trigger {
+		trigger, err := parseCron(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trigger
+	}
+
+	for _, tc := range []struct {
+		name    string
+		trigger Trigger
+		after   time.Time
+		want    time.Time // zero if it never fires
+	}{
+		{"clock later today", clockTrigger{days: allDays, hour: 18, minute: 30}, at(2026, 10, 16, 12, 0), at(2026, 10, 16, 18, 30)},
+		{"clock strictly after", clockTrigger{days: allDays, hour: 18, minute: 30}, at(2026, 10, 16, 18, 30), at(2026, 10, 17, 18, 30)},
+		{"clock weekdays from friday", clockTrigger{days: days{false, true, true, true, true, true, false}, hour: 6, minute: 45}, at(2026, 10, 16, 7, 0), at(2026, 10, 19, 6, 45)},
+		{"clock no days", clockTrigger{hour: 6, minute: 45}, at(2026, 10, 16, 7, 0), time.Time{}},
+		{"clock in the skipped hour", clockTrigger{days: allDays, hour: 2, minute: 30}, at(2026, 3, 29, 0, 0), at(2026, 3, 29, 3, 30)},
+		{"clock after the skipped hour", clockTrigger{days: allDays, hour: 2, minute: 30}, at(2026, 3, 29, 3, 30), at(2026, 3, 30, 2, 30)},
+		{"clock in the repeated hour", clockTrigger{days: allDays, hour: 2, minute: 30}, at(2026, 10, 25, 0, 0), at(2026, 10, 25, 2, 30)},
+		{"clock once in the repeated hour", clockTrigger{days: allDays, hour: 2, minute: 30}, at(2026, 10, 25, 2, 30), at(2026, 10, 26, 2, 30)},
+
+		{"cron every minute", cron("*", "*", "*", "*", "*"), at(2026, 10, 16, 12, 0), at(2026, 10, 16, 12, 1)},
+		{"cron daily", cron("0", "23", "*", "*", "*"), at(2026, 10, 16, 23, 0), at(2026, 10, 17, 23, 0)},
+		{"cron end of year", cron("0", "0", "1", "1", "*"), at(2026, 10, 16, 12, 0), at(2027, 1, 1, 0, 0)},
+		{"cron sunday as 7", cron("0", "9", "*", "*", "7"), at(2026, 10, 16, 12, 0), at(2026, 10, 18, 9, 0)},
+		{"cron day of month or week", cron("0", "9", "20", "*", "5"), at(2026, 10, 16, 12, 0), at(2026, 10, 20, 9, 0)},
+		{"cron day of week or month", cron("0", "9", "30", "*", "1"), at(2026, 10, 16, 12, 0), at(2026, 10, 19, 9, 0)},
+		{"cron february 29", cron("0", "0", "29", "2", "*"), at(2026, 10, 16, 12, 0), at(2028, 2, 29, 0, 0)},
+		{"cron february 30", cron("0", "0", "30", "2", "*"), at(2026, 10, 16, 12, 0), time.Time{}},
+		{"cron april 31", cron("0", "0", "31", "4", "*"), at(2026, 10, 16, 12, 0), time.Time{}},
+		{"cron 31st skips short months", cron("0", "0", "31", "*", "*"), at(2026, 11, 1, 0, 0), at(2026, 12, 31, 0, 0)},
+		{"cron in the skipped hour", cron("30", "2", "*", "*", "*"), at(2026, 3, 29, 0, 0), at(2026, 3, 29, 3, 30)},
+		{"cron hourly over the skipped hour", cron("0", "*", "*", "*", "*"), at(2026, 3, 29, 1, 30), at(2026, 3, 29, 3, 0)},
+		{"cron hourly after the skipped hour", cron("0", "*", "*", "*", "*"), at(2026, 3, 29, 3, 0), at(2026, 3, 29, 4, 0)},
+		{"cron once in the repeated hour", cron("30", "2", "*", "*", "*"), at(2026, 10, 25, 2, 30), at(2026, 10, 26, 2, 30)},
+	} {
+		have := tc.trigger.Next(tc.after)
+		if !tc.want.Equal(have) {
+			t.Errorf("%s: want %s, have %s", tc.name, tc.want, have)
+		}
+	}
+}
+
+func TestNextSolar(t *testing.T) {
+	var (
+		berlin = Location{Latitude: 52.520008, Longitude: 13.404954}
+		tromso = Location{Latitude: 69.649208, Longitude: 18.955324}
+		after  = time.Date(2026, 6, 21, 12, 0, 0, 0, time.UTC)
+	)
+
+	// Sunset in Berlin is in the evening, an offset moves it.
+	sunset := solarTrigger{days: allDays, event: "sunset", location: berlin}.Next(after)
+	if sunset.Day() != 21 || sunset.Hour() != 19 {
+		t.Errorf("berlin sunset: want about 19:30 UTC on the 21st, have %s", sunset)
+	}
+	early := solarTrigger{days: allDays, event: "sunset", offset: -30 * time.Minute, location: berlin}.Next(after)
+	if want, have := sunset.Add(-30*time.Minute), early; !want.Truncate(time.Second).Equal(have.Truncate(time.Second)) {
+		t.Errorf("berlin sunset-30m: want %s, have %s", want, have)
+	}
+
+	// An offset that moves the firing to the next day still fires.
+	late := solarTrigger{days: allDays, event: "sunset", offset: 6 * time.Hour, location: berlin}.Next(after)
+	if late.Day() != 22 {
+		t.Errorf("berlin sunset+6h: want the 22nd, have %s", late)
+	}
+
+	// The sun doesn't set in Tromsø until late July.
+	never := solarTrigger{days: allDays, event: "sunset", location: tromso}.Next(after)
+	if never.Before(time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC)) || never.After(time.Date(2026, 7, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("tromsø sunset: want late July, have %s", never)
+	}
+
+	// Firings are strictly after, and whole seconds.
+	again := solarTrigger{days: allDays, event: "sunset", location: berlin}.Next(sunset)
+	if again.Day() != 22 || again.Nanosecond() != 0 {
+		t.Errorf("berlin sunset after sunset: want the 22nd, whole seconds, have %s", again)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}